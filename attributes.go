@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// AttributeType is the type of the value of an attribute.
+type AttributeType int
+
+// The types of attribute values.
+const (
+	TypeUint8 AttributeType = iota // TypeUint8 is an integer from 0 to 255.
+	TypeBool                       // TypeBool is a boolean, written as 0 or 1.
+)
+
+func (t AttributeType) String() string {
+	switch t {
+	case TypeBool:
+		return "bool"
+	default:
+		return "uint8"
+	}
+}
+
+// Attribute describes a configurable TrackPoint attribute.
+type Attribute struct {
+	Name        string        // Name is the name in the SYSFS and the YAML key.
+	Aliases     []string      // Aliases are alternative names, e.g. of the command line flag.
+	Type        AttributeType // Type is the type of the value.
+	Min         uint8         // Min is the minimal value.
+	Max         uint8         // Max is the maximal value.
+	Default     uint8         // Default is the default value.
+	Description string        // Description describes the attribute.
+	Since       string        // Since is the first kernel version exposing the attribute.
+}
+
+// Attributes are all configurable TrackPoint attributes.
+var Attributes = []*Attribute{
+	{Name: "draghys", Type: TypeUint8, Max: 0xFF, Default: 0xFF, Since: "2.6.14",
+		Description: "Drag Hysteresis (how hard it is to drag with Z-axis pressed)."},
+	{Name: "thresh", Type: TypeUint8, Max: 0xFF, Default: 0x08, Since: "2.6.14",
+		Description: "Minimum value for a Z-axis press."},
+	{Name: "upthresh", Type: TypeUint8, Max: 0xFF, Default: 0xFF, Since: "2.6.14",
+		Description: "Used to generate a 'click' on Z-axis."},
+	{Name: "ztime", Type: TypeUint8, Max: 0xFF, Default: 0x26, Since: "2.6.14",
+		Description: "How sharp of a press."},
+	{Name: "sensitivity", Type: TypeUint8, Max: 0xFF, Default: 0x80, Since: "2.6.14",
+		Description: "Sensitivity."},
+	{Name: "inertia", Type: TypeUint8, Max: 0xFF, Default: 0x06, Since: "2.6.14",
+		Description: "Negative Inertia."},
+	{Name: "speed", Type: TypeUint8, Max: 0xFF, Default: 0x61, Since: "2.6.14",
+		Description: "Speed of TP Cursor."},
+	{Name: "reach", Type: TypeUint8, Max: 0xFF, Default: 0x0A, Since: "2.6.14",
+		Description: "Backup for Z-axis press."},
+	{Name: "mindrag", Type: TypeUint8, Max: 0xFF, Default: 0x14, Since: "2.6.14",
+		Description: "Minimum amount of force needed to trigger dragging."},
+	{Name: "jenks", Type: TypeUint8, Max: 0xFF, Default: 0x87, Since: "2.6.14",
+		Description: "Minimum curvature for double click."},
+	{Name: "drift_time", Aliases: []string{"drifttime"}, Type: TypeUint8, Max: 0xFF, Default: 0x05, Since: "3.19",
+		Description: "How long a 'hands off' condition must last for drift correction to occur."},
+	{Name: "press_to_select", Aliases: []string{"pts"}, Type: TypeBool, Max: 1, Default: 0, Since: "2.6.14",
+		Description: "If press-to-select should be active."},
+	{Name: "skipback", Type: TypeBool, Max: 1, Default: 0, Since: "2.6.14",
+		Description: "Suppress movement after drag release."},
+	{Name: "ext_dev", Aliases: []string{"extdev"}, Type: TypeBool, Max: 1, Default: 0, Since: "2.6.14",
+		Description: "Disable external device."},
+}
+
+// LookupAttribute finds the attribute by its name or one of its aliases.
+func LookupAttribute(name string) *Attribute {
+	for _, a := range Attributes {
+		if a.Name == name {
+			return a
+		}
+	}
+	for _, a := range Attributes {
+		if contains(a.Aliases, name) {
+			return a
+		}
+	}
+	return nil
+}
+
+// AttributeNames returns the names of all attributes.
+func AttributeNames() []string {
+	names := make([]string, len(Attributes))
+	for i, a := range Attributes {
+		names[i] = a.Name
+	}
+	return names
+}
+
+// Parse parses and validates a value of the attribute.
+func (a *Attribute) Parse(s string) (uint8, error) {
+	var v uint64
+	if a.Type == TypeBool {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %q is not a boolean", a.Name, s)
+		}
+		if b {
+			v = 1
+		}
+	} else {
+		var err error
+		if v, err = strconv.ParseUint(s, 0, 64); err != nil {
+			return 0, fmt.Errorf("%s: %q is not a number", a.Name, s)
+		}
+	}
+	if v < uint64(a.Min) || v > uint64(a.Max) {
+		return 0, fmt.Errorf("%s: %v is out of range %d-%d", a.Name, s, a.Min, a.Max)
+	}
+	return uint8(v), nil
+}
+
+// Format formats a value of the attribute as it is written to the SYSFS.
+func (a *Attribute) Format(v uint8) string {
+	return strconv.FormatUint(uint64(v), 10)
+}
+
+// attributeFlag is a command line flag of an attribute.
+type attributeFlag struct {
+	attr  *Attribute
+	value uint8
+}
+
+func (f *attributeFlag) String() string {
+	if f.attr == nil {
+		return ""
+	}
+	return f.attr.Format(f.value)
+}
+
+func (f *attributeFlag) Set(s string) (err error) {
+	f.value, err = f.attr.Parse(s)
+	return
+}
+
+func (f *attributeFlag) Get() interface{} { return f.value }
+
+func (f *attributeFlag) IsBoolFlag() bool { return f.attr != nil && f.attr.Type == TypeBool }
+
+// valuesType is the struct type the values section of the config file is
+// decoded to, so the YAML decoder reports unknown keys and invalid values
+// with their position.
+var valuesType = func() reflect.Type {
+	fields := make([]reflect.StructField, len(Attributes))
+	for i, a := range Attributes {
+		t := reflect.TypeOf((*uint8)(nil))
+		if a.Type == TypeBool {
+			t = reflect.TypeOf((*bool)(nil))
+		}
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("F%d", i),
+			Type: t,
+			Tag:  reflect.StructTag(fmt.Sprintf(`yaml:"%s"`, a.Name)),
+		}
+	}
+	return reflect.StructOf(fields)
+}()
+
+// decodeValues converts a decoded struct of valuesType to Values.
+func decodeValues(s reflect.Value) (Values, error) {
+	values := make(Values)
+	for i, a := range Attributes {
+		f := s.Field(i)
+		if f.IsNil() {
+			continue
+		}
+		var v uint8
+		switch f.Elem().Kind() {
+		case reflect.Bool:
+			if f.Elem().Bool() {
+				v = 1
+			}
+		default:
+			v = uint8(f.Elem().Uint())
+		}
+		if v < a.Min || v > a.Max {
+			return nil, fmt.Errorf("%s: %d is out of range %d-%d", a.Name, v, a.Min, a.Max)
+		}
+		values[a.Name] = v
+	}
+	return values, nil
+}
+
+// renameValuesType replaces the generated type in YAML errors.
+func renameValuesType(msg string) string {
+	return strings.Replace(msg, valuesType.String(), "main.Values", -1)
+}
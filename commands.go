@@ -0,0 +1,602 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Command is a sub command of the tool.
+type Command struct {
+	Name        string                    // Name is the name of the command.
+	Usage       string                    // Usage describes the arguments of the command.
+	Description string                    // Description describes what the command does.
+	Run         func(args []string) error // Run runs the command with the remaining arguments.
+}
+
+// ErrUsage indicates that a command was called with invalid arguments.
+var ErrUsage = errors.New("invalid arguments")
+
+// Commands are the sub commands of the tool.
+var Commands = []*Command{
+	{
+		Name:        "check-config",
+		Usage:       "<file>",
+		Description: "Validates a config file without touching a device.",
+		Run:         runCheckConfig,
+	},
+	{
+		Name:        "detect",
+		Usage:       "[sysfs path]",
+		Description: "Detects the TrackPoint and shows its variant and attributes.",
+		Run:         runDetect,
+	},
+	{
+		Name:        "model",
+		Usage:       "[models dir]",
+		Description: "Shows the entry of the model database matching this machine.",
+		Run:         runModel,
+	},
+	{
+		Name:        "attributes",
+		Description: "Lists the configurable attributes.",
+		Run:         runAttributes,
+	},
+	{
+		Name:        "export",
+		Usage:       "[options] <" + strings.Join(ExporterNames(), "|") + ">",
+		Description: "Writes the effective settings in a format that applies them without the daemon.",
+		Run:         runExport,
+	},
+	{
+		Name:        "libinput",
+		Usage:       "[options] [root]",
+		Description: "Installs the libinput quirks and the hwdb entry of the libinput section for this machine.",
+		Run:         runLibinput,
+	},
+	{
+		Name:        "apply",
+		Usage:       "[options]",
+		Description: "Writes the values to the device and installs the libinput section.",
+		Run:         runApply,
+	},
+	{
+		Name:        "install",
+		Usage:       "[-root dir] [-binary path] [-force]",
+		Description: "Installs the systemd unit, the udev rules and a default config.",
+		Run:         runInstall,
+	},
+	{
+		Name:        "uninstall",
+		Usage:       "[-root dir]",
+		Description: "Removes the installed files that were not changed since.",
+		Run:         runUninstall,
+	},
+	{
+		Name:        "helper",
+		Description: "Writes the attributes for the daemon that dropped its privileges, started by the daemon itself.",
+		Run:         runHelper,
+	},
+	{
+		Name:        "import",
+		Usage:       "[<" + strings.Join(ImporterNames(), "|") + "> [file]]",
+		Description: "Converts exported settings, or without arguments the settings found on this machine, to a config file.",
+		Run:         runImport,
+	},
+	{
+		Name:        "conflicts",
+		Description: "Lists udev rules, tmpfiles, units and processes that write the attributes too.",
+		Run:         runConflicts,
+	},
+	{
+		Name:        "set",
+		Usage:       "[-trial duration] [-run-dir dir] <attribute=value>...",
+		Description: "Sets values in the running daemon until the config is reloaded, reverted after the trial unless confirmed.",
+		Run:         runSet,
+	},
+	{
+		Name:        "profile",
+		Usage:       "[-trial duration] [-run-dir dir] <profile|" + NoProfile + ">",
+		Description: "Switches the profile of the running daemon, reverted after the trial unless confirmed.",
+		Run:         runProfile,
+	},
+	{
+		Name:        "history",
+		Usage:       "[-state-dir dir] [-n count]",
+		Description: "Lists the changes applied to the device.",
+		Run:         runHistory,
+	},
+	{
+		Name:        "rollback",
+		Usage:       "[-trial duration] [-run-dir dir] [-state-dir dir] <id>",
+		Description: "Applies the values the device had after a change of the history.",
+		Run:         runRollback,
+	},
+	{
+		Name:        "stop",
+		Usage:       "[-run-dir dir] [-timeout duration]",
+		Description: "Stops the running daemon and waits for it to exit.",
+		Run:         runStop,
+	},
+	{
+		Name:        "confirm",
+		Usage:       "[-run-dir dir]",
+		Description: "Keeps the changes the running daemon is trying.",
+		Run:         runConfirm,
+	},
+}
+
+// LookupCommand finds the sub command with the given name.
+func LookupCommand(name string) *Command {
+	for _, c := range Commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// RunCommand runs the command and exits on error.
+func RunCommand(c *Command, args []string) {
+	err := c.Run(args)
+	if err == ErrUsage {
+		fmt.Fprintf(os.Stderr, "usage: %s %s %s\n", os.Args[0], c.Name, c.Usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func runCheckConfig(args []string) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+	if err := CheckConfig(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("%s: ok\n", args[0])
+	return nil
+}
+
+func runAttributes(args []string) error {
+	if len(args) != 0 {
+		return ErrUsage
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tALIASES\tTYPE\tRANGE\tDEFAULT\tSINCE\tDESCRIPTION")
+	for _, a := range Attributes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d-%d\t%d\t%s\t%s\n", a.Name, strings.Join(a.Aliases, ","),
+			a.Type, a.Min, a.Max, a.Default, a.Since, a.Description)
+	}
+	return w.Flush()
+}
+
+func runDetect(args []string) (err error) {
+	var d *Device
+	switch len(args) {
+	case 0:
+		if d, err = FindDevice(); err != nil {
+			return err
+		}
+	case 1:
+		d = ReadDevice(args[0])
+	default:
+		return ErrUsage
+	}
+	available, err := NewSettingsReaderWriter(d.Path).Attributes()
+	if err != nil {
+		return err
+	}
+	var supported, unsupported []string
+	for _, a := range Attributes {
+		if d.Variant.Supports(a.Name) && contains(available, a.Name) {
+			supported = append(supported, a.Name)
+		} else {
+			unsupported = append(unsupported, a.Name)
+		}
+	}
+	defaults := d.Variant.Defaults()
+	fmt.Printf("path:        %s\n", d.Path)
+	fmt.Printf("name:        %s\n", d.Name)
+	fmt.Printf("phys:        %s\n", d.Phys)
+	fmt.Printf("variant:     %s\n", d.Variant)
+	fmt.Printf("supported:   %s\n", strings.Join(supported, " "))
+	fmt.Printf("unsupported: %s\n", strings.Join(unsupported, " "))
+	fmt.Println("defaults:")
+	for _, a := range Attributes {
+		if v, ok := defaults[a.Name]; ok {
+			fmt.Printf("  %s: %s\n", a.Name, a.Format(v))
+		}
+	}
+	return nil
+}
+
+func runModel(args []string) error {
+	dir := DefaultModelsDir
+	switch len(args) {
+	case 0:
+	case 1:
+		dir = args[0]
+	default:
+		return ErrUsage
+	}
+	models, err := LoadModels(dir)
+	if err != nil {
+		return err
+	}
+	variant := VariantUnknown
+	if d, err := findDevice(SysfsBaseDir); err == nil {
+		variant = d.Variant
+	}
+	dmi := ReadDMI()
+	fmt.Printf("vendor:  %s\n", dmi.Vendor)
+	fmt.Printf("product: %s\n", dmi.Product)
+	fmt.Printf("version: %s\n", dmi.Version)
+	fmt.Printf("variant: %s\n", variant)
+	m := MatchModel(models, dmi, variant)
+	if m == nil {
+		fmt.Println("model:   no match")
+		return nil
+	}
+	fmt.Printf("model:   %s (%s)\n", m.Name, m.Source)
+	fmt.Println("values:")
+	for _, a := range Attributes {
+		if v, ok := m.Values[a.Name]; ok {
+			fmt.Printf("  %s: %s\n", a.Name, a.Format(v))
+		}
+	}
+	return nil
+}
+
+func runSet(args []string) error {
+	return sendControl("set", args, true, func(n int) bool { return n > 0 })
+}
+
+func runProfile(args []string) error {
+	return sendControl("profile", args, true, func(n int) bool { return n == 1 })
+}
+
+func runConfirm(args []string) error {
+	return sendControl("confirm", args, false, func(n int) bool { return n == 0 })
+}
+
+// sendControl parses the flags of a control command, checks the number of
+// the remaining arguments and sends them to the running daemon.
+func sendControl(command string, args []string, trial bool, valid func(n int) bool) error {
+	fs, runDir, req := newControlFlags(command, trial)
+	if err := fs.Parse(args); err != nil || !valid(fs.NArg()) {
+		return ErrUsage
+	}
+	req.Args = fs.Args()
+	return sendRequest(*runDir, req)
+}
+
+// newControlFlags creates the flags shared by the control commands.
+func newControlFlags(command string, trial bool) (fs *flag.FlagSet, runDir *string, req *ControlRequest) {
+	fs = flag.NewFlagSet(command, flag.ContinueOnError)
+	runDir = fs.String("run-dir", DefaultRunDir, "The directory of the control socket.")
+	req = &ControlRequest{Command: command, Source: SourceCLI}
+	if trial {
+		fs.DurationVar(&req.Trial, "trial", DefaultTrial, "How long the change is tried before it is reverted unless confirmed, 0 keeps it right away.")
+	}
+	return fs, runDir, req
+}
+
+func sendRequest(runDir string, req *ControlRequest) error {
+	resp, err := SendControl(filepath.Join(runDir, ControlSocketName), req)
+	if err != nil {
+		return err
+	}
+	fmt.Println(resp.Message)
+	if req.Trial > 0 {
+		fmt.Printf("run \"%s confirm\" to keep the change\n", os.Args[0])
+	}
+	return nil
+}
+
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	stateDir := fs.String("state-dir", DefaultStateDir, "The directory for persistent state.")
+	n := fs.Int("n", 20, "The number of entries to show, 0 shows all.")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return ErrUsage
+	}
+	entries, err := NewHistory(*stateDir).Read()
+	if err != nil {
+		return err
+	}
+	if *n > 0 && len(entries) > *n {
+		entries = entries[len(entries)-*n:]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tSOURCE\tCHANGES")
+	for _, e := range entries {
+		changes := make([]string, len(e.Changes))
+		for i, c := range e.Changes {
+			changes[i] = c.String()
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", e.ID, e.Time.Local().Format("2006-01-02 15:04:05"),
+			e.Source, strings.Join(changes, ", "))
+	}
+	return w.Flush()
+}
+
+// runRollback rolls back through the daemon. If it does not run, the
+// values are written directly.
+func runRollback(args []string) error {
+	fs, runDir, req := newControlFlags("rollback", true)
+	stateDir := fs.String("state-dir", DefaultStateDir, "The directory for persistent state.")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return ErrUsage
+	}
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return ErrUsage
+	}
+	req.Args = fs.Args()
+	err = sendRequest(*runDir, req)
+	if e, ok := err.(*net.OpError); !ok || e.Op != "dial" {
+		return err
+	}
+	h := NewHistory(*stateDir)
+	e, err := h.Lookup(id)
+	if err != nil {
+		return err
+	} else if e == nil {
+		return fmt.Errorf("no history entry %d", id)
+	}
+	s := newSettings()
+	s.Unset = UnsetIgnore
+	if s.SysfsPath, err = checkDevice(SysfsBaseDir, e.Device); err != nil {
+		return err
+	}
+	if s.Values, s.Extra, err = e.Settings(); err != nil {
+		return err
+	}
+	s.RunDir, s.StateDir = *runDir, *stateDir
+	if err = applyOnce(s, SourceRollback, false); err != nil {
+		return err
+	}
+	fmt.Printf("rolled back to %d\n", id)
+	return nil
+}
+
+// runStop stops the daemon with the pidfile in the run directory.
+func runStop(args []string) error {
+	fs := flag.NewFlagSet("stop", flag.ContinueOnError)
+	runDir := fs.String("run-dir", DefaultRunDir, "The directory of the pidfile.")
+	timeout := fs.Duration("timeout", StopTimeout, "How long to wait for the daemon to exit.")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return ErrUsage
+	}
+	pid, err := StopDaemon(*runDir, *timeout)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("stopped the daemon (pid %d)\n", pid)
+	return nil
+}
+
+// runExport loads the settings with the options of the tool and exports them.
+func runExport(args []string) error {
+	flags, err := ParseFlags(append([]string{"export"}, args...))
+	if err != nil || len(flags.Args) != 1 {
+		return ErrUsage
+	}
+	export, ok := Exporters[flags.Args[0]]
+	if !ok {
+		return ErrUsage
+	}
+	settings, err := LoadSettings(flags)
+	if err != nil {
+		return err
+	}
+	if err = settings.Validate(); err != nil {
+		return err
+	}
+	if settings.OnAC != nil || settings.OnBattery != nil || settings.OnMouse != nil || settings.ManageExtDev {
+		fmt.Fprintln(os.Stderr, "warning: on_ac, on_battery, on_mouse and manage_ext_dev need the daemon and are not exported")
+	}
+	return export(settings, os.Stdout)
+}
+
+// loadValidSettings loads and validates the settings for the command with the arguments.
+func loadValidSettings(name string, args []string) (*Settings, error) {
+	flags, err := ParseFlags(append([]string{name}, args...))
+	if err != nil {
+		return nil, ErrUsage
+	}
+	settings, err := LoadSettings(flags)
+	if err != nil {
+		return nil, err
+	}
+	return settings, settings.Validate()
+}
+
+// runLibinput installs the libinput section below the given root or /.
+func runLibinput(args []string) error {
+	settings, err := loadValidSettings("libinput", args)
+	if err != nil {
+		return err
+	}
+	root := "/"
+	switch len(settings.Flags.Args) {
+	case 0:
+	case 1:
+		root = settings.Flags.Args[0]
+	default:
+		return ErrUsage
+	}
+	installed, err := InstallLibinput(settings, root)
+	for _, path := range installed {
+		fmt.Println(path)
+	}
+	return err
+}
+
+// runApply writes the values to the device and installs the libinput
+// section, so one config describes the whole behaviour of the pointer.
+func runApply(args []string) error {
+	settings, err := loadValidSettings("apply", args)
+	if err != nil {
+		return err
+	}
+	if len(settings.Flags.Args) != 0 {
+		return ErrUsage
+	}
+	if err = applyOnce(settings, SourceStartup, settings.Flags.Wait()); err != nil {
+		return err
+	}
+	if settings.Libinput == nil {
+		return nil
+	}
+	installed, err := InstallLibinput(settings, "/")
+	for _, path := range installed {
+		fmt.Println(path)
+	}
+	if err == nil && settings.Libinput.Multiplier != 0 {
+		fmt.Fprintln(os.Stderr, "libinput reads the quirks when it starts, log in again to use them")
+	}
+	return err
+}
+
+// runInstall installs the daemon below the root.
+func runInstall(args []string) error {
+	fs := flag.NewFlagSet("install", flag.ContinueOnError)
+	root := fs.String("root", "/", "The prefix of the installed files, e.g. of an image.")
+	binary := fs.String("binary", "", "The path of the binary in the unit. (default is this binary)")
+	force := fs.Bool("force", false, "Replace files that were changed locally.")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return ErrUsage
+	}
+	if *binary == "" {
+		path, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		if *binary, err = filepath.EvalSymlinks(path); err != nil {
+			return err
+		}
+	}
+	artifacts, err := Artifacts(*binary)
+	if err != nil {
+		return err
+	}
+	written, err := Install(*root, artifacts, *force)
+	for _, path := range written {
+		fmt.Println(path)
+	}
+	if err != nil {
+		return err
+	}
+	if *root == "/" {
+		execLogged([]string{"systemctl", "daemon-reload"}, []string{"udevadm", "control", "--reload"})
+		fmt.Println("enable the daemon with \"systemctl enable --now trackpoint\"")
+	}
+	return nil
+}
+
+// runUninstall removes the installed files below the root.
+func runUninstall(args []string) error {
+	fs := flag.NewFlagSet("uninstall", flag.ContinueOnError)
+	root := fs.String("root", "/", "The prefix of the installed files.")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return ErrUsage
+	}
+	if *root == "/" {
+		execLogged([]string{"systemctl", "disable", "--now", "trackpoint"})
+	}
+	removed, kept, err := Uninstall(*root)
+	for _, path := range removed {
+		fmt.Println(path)
+	}
+	for _, path := range kept {
+		fmt.Fprintf(os.Stderr, "%s: changed locally, kept\n", path)
+	}
+	if err == nil && *root == "/" {
+		execLogged([]string{"systemctl", "daemon-reload"}, []string{"udevadm", "control", "--reload"})
+	}
+	return err
+}
+
+// runImport imports a file, or scans the ImportSources without arguments,
+// and writes the values as a config file.
+func runImport(args []string) error {
+	var (
+		assignments []Assignment
+		files       []string
+		err         error
+	)
+	switch len(args) {
+	case 0:
+		if assignments, files, err = ScanImports("/"); err != nil {
+			return err
+		}
+	case 1, 2:
+		parse, ok := Importers[args[0]]
+		if !ok {
+			return ErrUsage
+		}
+		if len(args) == 1 || args[1] == "-" {
+			assignments, err = parse(os.Stdin, "stdin")
+			files = []string{"stdin"}
+		} else {
+			assignments, err = importFile(args[0], args[1])
+			files = args[1:]
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return ErrUsage
+	}
+	if len(assignments) == 0 {
+		return errors.New("no values found")
+	}
+	c, conflicts, rejected := Merge(assignments)
+	bytes, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	fmt.Println("# Imported by trackpoint from:")
+	for _, f := range files {
+		fmt.Printf("#   %s\n", f)
+	}
+	for _, conflict := range conflicts {
+		fmt.Fprintf(os.Stderr, "warning: conflicting values for %v\n", conflict)
+		fmt.Printf("# conflict, the last value is used: %v\n", conflict)
+	}
+	for _, r := range rejected {
+		fmt.Fprintf(os.Stderr, "warning: skipped %v\n", r)
+		fmt.Printf("# skipped %v\n", r)
+	}
+	_, err = os.Stdout.Write(bytes)
+	return err
+}
+
+func runConflicts(args []string) error {
+	if len(args) != 0 {
+		return ErrUsage
+	}
+	managers, err := FindManagers("/")
+	if err != nil {
+		return err
+	}
+	if len(managers) == 0 {
+		fmt.Println("no other writers found")
+	}
+	for _, m := range managers {
+		fmt.Println(m)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConfigError is an error in a config file.
+type ConfigError struct {
+	File   string // File is the path of the config file.
+	Line   int    // Line is the 1-based line of the error, 0 if unknown.
+	Column int    // Column is the 1-based column of the error, 0 if unknown.
+	Msg    string // Msg describes the error.
+}
+
+func (e *ConfigError) Error() string {
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	default:
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+}
+
+// ConfigErrors are all errors found in a config file.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+var (
+	yamlLinePattern         = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	yamlTokenPattern        = regexp.MustCompile("`([^`]*)`")
+	yamlUnknownFieldPattern = regexp.MustCompile(`^field (\S+) not found in type (\S+)$`)
+	yamlRangePattern        = regexp.MustCompile("^cannot unmarshal !!int `([^`]*)` into uint8$")
+)
+
+// configKeys are the keys of the types of the config file by their name in YAML errors.
+var configKeys = map[string][]string{
+	"main.Settings":   yamlKeys(reflect.TypeOf(Settings{})),
+	"main.Values":     AttributeNames(),
+	"main.Model":      yamlKeys(reflect.TypeOf(Model{})),
+	"main.MatchBlock": yamlKeys(reflect.TypeOf(MatchBlock{})),
+	"main.Overlay":    yamlKeys(reflect.TypeOf(Overlay{})),
+	"main.Libinput":   yamlKeys(reflect.TypeOf(Libinput{})),
+}
+
+// newConfigErrors converts an error of the YAML parser to positioned errors.
+func newConfigErrors(file string, src []byte, err error) ConfigErrors {
+	var errs ConfigErrors
+	for _, msg := range strings.Split(err.Error(), "\n") {
+		msg = strings.TrimSpace(msg)
+		if msg == "" || msg == "yaml: unmarshal errors:" {
+			continue
+		}
+		e := &ConfigError{File: file, Msg: strings.TrimPrefix(msg, "yaml: ")}
+		if m := yamlLinePattern.FindStringSubmatch(msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Msg = m[2]
+			if m := yamlUnknownFieldPattern.FindStringSubmatch(e.Msg); m != nil {
+				e.Msg = unknownKeyMessage(m[1], configKeys[m[2]])
+				e.Column = column(src, e.Line, m[1])
+			} else if t := yamlTokenPattern.FindStringSubmatch(e.Msg); t != nil {
+				e.Column = column(src, e.Line, t[1])
+				if m := yamlRangePattern.FindStringSubmatch(e.Msg); m != nil {
+					e.Msg = fmt.Sprintf("%s: %s is out of range 0-255", yamlKey(src, e.Line), m[1])
+				}
+			}
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// column finds the 1-based column of token in the 1-based line of src or 0.
+func column(src []byte, line int, token string) int {
+	lines := strings.Split(string(src), "\n")
+	if token == "" || line < 1 || line > len(lines) {
+		return 0
+	}
+	return strings.Index(lines[line-1], token) + 1
+}
+
+// yamlKey returns the YAML key in the 1-based line of src.
+func yamlKey(src []byte, line int) string {
+	lines := strings.Split(string(src), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	k := strings.SplitN(lines[line-1], ":", 2)[0]
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(k), "-"))
+}
+
+// unknownKeyMessage describes the unknown key k and suggests one of the known keys.
+func unknownKeyMessage(k string, keys []string) string {
+	if a := LookupAttribute(k); a != nil && a.Name != k && contains(keys, a.Name) {
+		return fmt.Sprintf("unknown key %q, %q is the command line flag, did you mean %q?", k, k, a.Name)
+	}
+	if suggestion := suggest(k, keys); suggestion != "" {
+		return fmt.Sprintf("unknown key %q, did you mean %q?", k, suggestion)
+	}
+	return fmt.Sprintf("unknown key %q", k)
+}
+
+// yamlKeys returns the YAML keys of the struct type t.
+func yamlKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("yaml"), ",")
+		name := tag[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if contains(tag[1:], "inline") {
+			keys = append(keys, yamlKeys(f.Type)...)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
+
+// suggest returns the candidate closest to s or an empty string if none is close enough.
+func suggest(s string, candidates []string) (suggestion string) {
+	best := len(s)/3 + 1
+	normalized := strings.NewReplacer("-", "", "_", "").Replace(s)
+	for _, c := range candidates {
+		d := levenshtein(s, c)
+		if strings.NewReplacer("-", "", "_", "").Replace(c) == normalized {
+			d = 0
+		}
+		if d <= best {
+			best, suggestion = d, c
+		}
+	}
+	return
+}
+
+// levenshtein computes the edit distance of a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckConfig validates the config file at path without touching a device.
+func CheckConfig(path string) error {
+	s := NewSettings()
+	if err := s.ReadYAML(path); err != nil {
+		return err
+	}
+	return s.validateConfig()
+}
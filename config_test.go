@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) (path string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "trackpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "trackpoint.yml")
+	if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestConfigErrors(t *testing.T) {
+	path, cleanup := writeConfig(t, "values:\n  speed: 1\n  sensitivity: fast\n")
+	defer cleanup()
+
+	err := NewSettings().ReadYAML(path)
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("expected ConfigErrors, got %v", err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected %v errors, got %v", 1, len(errs))
+	}
+	if errs[0].File != path || errs[0].Line != 3 || errs[0].Column != 16 {
+		t.Fatalf("expected %v:3:16, got %v:%v:%v", path, errs[0].File, errs[0].Line, errs[0].Column)
+	}
+
+	path2, cleanup2 := writeConfig(t, "values:\n  speed: 1\n sensitivity: 2\n")
+	defer cleanup2()
+	err = NewSettings().ReadYAML(path2)
+	errs, ok = err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("expected ConfigErrors, got %v", err)
+	}
+	if errs[0].Line == 0 {
+		t.Fatalf("expected a line, got %v", errs[0])
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	if err := CheckConfig("./trackpoint.yml"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		content string
+		line    int
+		column  int
+		msg     string
+	}{
+		{"values:\n  sensitivty: 1\n", 2, 3, `unknown key "sensitivty", did you mean "sensitivity"?`},
+		{"values:\n  drifttime: 1\n", 2, 3, `unknown key "drifttime", "drifttime" is the command line flag, did you mean "drift_time"?`},
+		{"values:\n  drift-time: 1\n", 2, 3, `unknown key "drift-time", did you mean "drift_time"?`},
+		{"intervall: 1s\n", 1, 1, `unknown key "intervall", did you mean "interval"?`},
+		{"values:\n  speed: 300\n", 2, 10, "speed: 300 is out of range 0-255"},
+		{"interval: -1s\n", 0, 0, "interval must be positive"},
+	}
+	for _, test := range tests {
+		path, cleanup := writeConfig(t, test.content)
+		err := CheckConfig(path)
+		cleanup()
+		errs, ok := err.(ConfigErrors)
+		if !ok || len(errs) != 1 {
+			t.Fatalf("expected one config error, got %v", err)
+		}
+		if errs[0].Line != test.line || errs[0].Column != test.column || errs[0].Msg != test.msg {
+			t.Fatalf("expected %v:%v: %v, got %v:%v: %v", test.line, test.column, test.msg, errs[0].Line, errs[0].Column, errs[0].Msg)
+		}
+	}
+}
+
+func TestCheckConfigMatch(t *testing.T) {
+	path, cleanup := writeConfig(t, "match:\n  - hostnme: x\n    profile: nope\n")
+	defer cleanup()
+	err := CheckConfig(path)
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 1 || errs[0].Msg != `unknown key "hostnme", did you mean "hostname"?` {
+		t.Fatalf("expected unknown key error, got %v", err)
+	}
+
+	path2, cleanup2 := writeConfig(t, "match:\n  - hostname: x\n    profile: nope\n")
+	defer cleanup2()
+	if err = CheckConfig(path2); err == nil {
+		t.Fatal("expected unknown profile error")
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProcDir is the directory of the processes.
+var ProcDir = "/proc"
+
+var (
+	// ConflictCheckDelay is the time after a write at which the daemon checks
+	// if someone else changed the values.
+	ConflictCheckDelay = 5 * time.Second
+	// ConflictThreshold is the number of consecutive external changes after
+	// which the daemon stops writing an attribute.
+	ConflictThreshold = 3
+)
+
+// ManagerSources are the places searched by FindManagers for other writers
+// of the TrackPoint attributes.
+var ManagerSources = []ImportSource{
+	{"/etc/udev/rules.d/*.rules", "udev"},
+	{"/run/udev/rules.d/*.rules", "udev"},
+	{"/usr/lib/udev/rules.d/*.rules", "udev"},
+	{"/lib/udev/rules.d/*.rules", "udev"},
+	{"/etc/tmpfiles.d/*.conf", "tmpfiles"},
+	{"/run/tmpfiles.d/*.conf", "tmpfiles"},
+	{"/usr/lib/tmpfiles.d/*.conf", "tmpfiles"},
+	{"/etc/rc.local", "script"},
+	{"/etc/systemd/system/*.service", "script"},
+}
+
+// WritingCommands are the sub commands that write to the device. Other
+// instances running other sub commands, e.g. history, are no writers.
+var WritingCommands = []string{"apply", "rollback"}
+
+// Manager is something else that writes the TrackPoint attributes.
+type Manager struct {
+	Source string   // Source is the file or the process.
+	Keys   []string // Keys are the attributes it writes, nil if unknown.
+}
+
+// Writes checks if the manager writes the attribute.
+func (m *Manager) Writes(key string) bool {
+	return m.Keys == nil || contains(m.Keys, key)
+}
+
+func (m *Manager) String() string {
+	if m.Keys == nil {
+		return m.Source
+	}
+	return fmt.Sprintf("%s (%s)", m.Source, strings.Join(m.Keys, ", "))
+}
+
+// FindManagers searches the ManagerSources below root for files writing
+// known attributes and ProcDir for other running instances of the tool.
+func FindManagers(root string) ([]*Manager, error) {
+	assignments, files, err := scanSources(root, ManagerSources)
+	if err != nil {
+		return nil, err
+	}
+	var managers []*Manager
+	for _, file := range files {
+		m := &Manager{Source: file, Keys: []string{}}
+		for _, a := range assignments {
+			attr := LookupAttribute(a.Key)
+			if attr != nil && strings.HasPrefix(a.Source, file+":") && !contains(m.Keys, attr.Name) {
+				m.Keys = append(m.Keys, attr.Name)
+			}
+		}
+		if len(m.Keys) > 0 {
+			managers = append(managers, m)
+		}
+	}
+	processes, err := findInstances()
+	if err != nil {
+		return nil, err
+	}
+	return append(managers, processes...), nil
+}
+
+// findInstances finds the other running instances of the tool that write
+// to the device: daemons, one-shot runs and the WritingCommands.
+func findInstances() ([]*Manager, error) {
+	dirs, err := ioutil.ReadDir(ProcDir)
+	if err != nil {
+		return nil, err
+	}
+	self := filepath.Base(os.Args[0])
+	var pids []int
+	for _, d := range dirs {
+		// the parent waiting for a detached daemon and the children, like the
+		// helper, belong to this instance
+		pid, err := strconv.Atoi(d.Name())
+		if err == nil && pid != os.Getpid() && pid != os.Getppid() && parentPid(pid) != os.Getpid() {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	var managers []*Manager
+	for _, pid := range pids {
+		cmdline, err := ioutil.ReadFile(filepath.Join(ProcDir, strconv.Itoa(pid), "cmdline"))
+		if err != nil || len(cmdline) == 0 {
+			continue
+		}
+		args := strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00")
+		if name := filepath.Base(args[0]); name != "trackpoint" && name != self {
+			continue
+		}
+		if len(args) > 1 && !strings.HasPrefix(args[1], "-") && !contains(WritingCommands, args[1]) {
+			continue
+		}
+		managers = append(managers, &Manager{Source: fmt.Sprintf("process %d (%s)", pid, strings.Join(args, " "))})
+	}
+	return managers, nil
+}
+
+// parentPid returns the pid of the parent of the process, 0 if unknown.
+func parentPid(pid int) int {
+	stat, err := ioutil.ReadFile(filepath.Join(ProcDir, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0
+	}
+	// the name in parentheses may contain spaces
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
+
+// conflicts tracks the attributes that someone else changes right after
+// the daemon wrote them.
+type conflicts struct {
+	sync.Mutex
+	managers  []*Manager        // managers are the other writers found at startup.
+	written   map[string]string // written are the values read back after the last apply.
+	counts    map[string]int    // counts are the consecutive external changes.
+	contested map[string]bool   // contested are the attributes no longer written.
+}
+
+func newConflicts() *conflicts {
+	return &conflicts{counts: make(map[string]int), contested: make(map[string]bool)}
+}
+
+// reset writes the contested attributes again.
+func (c *conflicts) reset() {
+	c.Lock()
+	defer c.Unlock()
+	c.counts = make(map[string]int)
+	c.contested = make(map[string]bool)
+}
+
+// culprits describes the managers that write the attribute.
+func (c *conflicts) culprits(key string) string {
+	var culprits []string
+	for _, m := range c.managers {
+		if m.Writes(key) {
+			culprits = append(culprits, m.Source)
+		}
+	}
+	if len(culprits) == 0 {
+		return "an unknown program"
+	}
+	return strings.Join(culprits, " or ")
+}
+
+// warnManagers warns about the other writers of the attributes the daemon
+// writes.
+func (d *SettingsDaemon) warnManagers() {
+	managers, err := FindManagers("/")
+	if err != nil {
+		d.emit(Event{Type: EventConflict, Time: time.Now(), Message: fmt.Sprintf("could not search for other writers: %v", err)})
+		return
+	}
+	d.conflicts.Lock()
+	d.conflicts.managers = managers
+	d.conflicts.Unlock()
+	d.RLock()
+	settings := d.Settings.Effective(d.state)
+	d.RUnlock()
+	for _, m := range managers {
+		var keys []string
+		settings.ForEach(func(key, _ string) error {
+			if m.Writes(key) {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+		if len(keys) > 0 {
+			d.emit(Event{Type: EventConflict, Time: time.Now(),
+				Message: fmt.Sprintf("%s also writes %s, it and the daemon may overwrite each other", m.Source, strings.Join(keys, ", "))})
+		}
+	}
+}
+
+// checkConflicts compares the device to the values read back after the last
+// apply. An attribute changed by someone else ConflictThreshold times in a
+// row is no longer written until the config is reloaded.
+func (d *SettingsDaemon) checkConflicts() {
+	// the values read during a write are no conflict
+	d.writing.Lock()
+	d.RLock()
+	rw := d.rw
+	d.RUnlock()
+	c := d.conflicts
+	c.Lock()
+	written := c.written
+	c.Unlock()
+	keys := make([]string, 0, len(written))
+	for key := range written {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	current, err := rw.Snapshot(keys)
+	d.writing.Unlock()
+	if err != nil {
+		return
+	}
+	var events []Event
+	c.Lock()
+	for _, key := range keys {
+		if _, ok := current[key]; !ok {
+			continue
+		}
+		if sameValue(key, current[key], written[key]) {
+			c.counts[key] = 0
+			continue
+		}
+		c.counts[key]++
+		if c.counts[key] >= ConflictThreshold && !c.contested[key] {
+			c.contested[key] = true
+			events = append(events, Event{Type: EventConflict, Time: time.Now(),
+				Message: fmt.Sprintf("%s was changed to %s right after it was written %d times, likely by %s; "+
+					"it is no longer written until the config is reloaded", key, current[key], c.counts[key], c.culprits(key))})
+		}
+	}
+	c.Unlock()
+	for _, e := range events {
+		d.emit(e)
+	}
+}
+
+// uncontested removes the contested attributes from the settings and
+// remembers the values that are written for checkConflicts.
+func (c *conflicts) uncontested(s *Settings) *Settings {
+	c.Lock()
+	defer c.Unlock()
+	u := *s
+	u.Values, u.Extra = make(Values), make(Extra)
+	c.written = make(map[string]string)
+	s.ForEach(func(key, value string) error {
+		if c.contested[key] {
+			return nil
+		}
+		c.written[key] = value
+		if LookupAttribute(key) != nil {
+			u.Values.Set(key, value)
+		} else {
+			u.Extra[key] = value
+		}
+		return nil
+	})
+	return &u
+}
+
+// settle replaces the written values with the values the device reports
+// right after the write, which may differ from the config, e.g. if the
+// device clamps a value.
+func (c *conflicts) settle(rw *SettingsReaderWriter) {
+	c.Lock()
+	keys := make([]string, 0, len(c.written))
+	for key := range c.written {
+		keys = append(keys, key)
+	}
+	c.Unlock()
+	snapshot, err := rw.Snapshot(keys)
+	if err != nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for key, value := range snapshot {
+		c.written[key] = value
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindManagers(t *testing.T) {
+	old := ProcDir
+	ProcDir = "testdata/proc"
+	defer func() { ProcDir = old }()
+
+	managers, err := FindManagers("testdata/import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"testdata/import/etc/udev/rules.d/70-mouse.rules (speed)",
+		"testdata/import/etc/udev/rules.d/90-TrackPoint.rules (sensitivity, press_to_select)",
+		"testdata/import/etc/tmpfiles.d/trackpoint.conf (sensitivity, speed)",
+		"testdata/import/etc/rc.local (inertia)",
+		"testdata/import/etc/systemd/system/trackpoint.service (press_to_select, drift_time)",
+		"process 4242 (/usr/local/bin/trackpoint --daemon --config /etc/trackpoint.yml)",
+		"process 4444 (trackpoint apply -sysfs /sys/devices/platform/i8042/serio1)",
+	}
+	if len(managers) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, managers)
+	}
+	for i, m := range managers {
+		if m.String() != expected[i] {
+			t.Fatalf("expected %v, got %v", expected[i], m)
+		}
+	}
+	if !managers[5].Writes("speed") || managers[0].Writes("sensitivity") {
+		t.Fatal("expected processes to write everything and files only their attributes")
+	}
+}
+
+func TestFindInstances(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := ProcDir
+	ProcDir = dir
+	defer func() { ProcDir = old }()
+
+	processes := []struct {
+		pid, ppid int
+		cmdline   string
+	}{
+		{100, os.Getpid(), "trackpoint\x00--daemon"},
+		{101, 100, "trackpoint\x00helper"},
+		{102, 1, "trackpoint\x00--daemon"},
+		{os.Getppid(), 1, "trackpoint\x00--detach"},
+	}
+	for _, p := range processes {
+		d := filepath.Join(dir, strconv.Itoa(p.pid))
+		if err = os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		stat := fmt.Sprintf("%d (track point) S %d 0 0", p.pid, p.ppid)
+		if err = ioutil.WriteFile(filepath.Join(d, "stat"), []byte(stat), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(d, "cmdline"), []byte(p.cmdline), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	managers, err := findInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(managers) != 1 || managers[0].Source != "process 102 (trackpoint --daemon)" {
+		t.Fatalf("expected only process 102, got %v", managers)
+	}
+}
+
+func TestSettingsDaemon_CheckConflicts(t *testing.T) {
+	dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128", "speed": "97"})
+	defer cleanup()
+	old := ConflictCheckDelay
+	ConflictCheckDelay = time.Hour
+	defer func() { ConflictCheckDelay = old }()
+
+	d := newTestDaemon(t, dir)
+	d.conflicts.managers = []*Manager{{Source: "/etc/udev/rules.d/90-tp.rules", Keys: []string{"sensitivity"}}}
+	var events []Event
+	d.OnEvent = func(e Event) { events = append(events, e) }
+
+	for i := 0; i < ConflictThreshold; i++ {
+		if err := d.applySettings(SourceInterval); err != nil {
+			t.Fatal(err)
+		}
+		// someone else writes right after the daemon
+		if err := ioutil.WriteFile(filepath.Join(dir, "sensitivity"), []byte("50\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		d.checkConflicts()
+	}
+	if len(events) != 1 || events[0].Type != EventConflict {
+		t.Fatalf("expected a conflict event, got %v", events)
+	}
+	if !strings.Contains(events[0].Message, "likely by /etc/udev/rules.d/90-tp.rules") {
+		t.Fatalf("expected the culprit in %q", events[0].Message)
+	}
+
+	// the contested attribute is left alone until the config is reloaded
+	if err := d.applySettings(SourceInterval); err != nil {
+		t.Fatal(err)
+	}
+	if v := readAttribute(t, dir, "sensitivity"); v != "50" {
+		t.Fatalf("expected %v, got %v", "50", v)
+	}
+	d.conflicts.reset()
+	if err := d.applySettings(SourceInterval); err != nil {
+		t.Fatal(err)
+	}
+	if v := readAttribute(t, dir, "sensitivity"); v != "128" {
+		t.Fatalf("expected %v, got %v", "128", v)
+	}
+}
+
+func TestSettingsDaemon_CheckConflictsSameValue(t *testing.T) {
+	dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128", "press_to_select": "0"})
+	defer cleanup()
+	old := ConflictCheckDelay
+	ConflictCheckDelay = time.Hour
+	defer func() { ConflictCheckDelay = old }()
+
+	d := newTestDaemon(t, dir)
+	d.Settings.Values["press_to_select"] = 1
+	var events []Event
+	d.OnEvent = func(e Event) { events = append(events, e) }
+
+	for i := 0; i < ConflictThreshold; i++ {
+		if err := d.applySettings(SourceInterval); err != nil {
+			t.Fatal(err)
+		}
+		// someone else writes the same values in another notation
+		if err := ioutil.WriteFile(filepath.Join(dir, "sensitivity"), []byte("0x80\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "press_to_select"), []byte("true\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		d.checkConflicts()
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no conflict, got %v", events)
+	}
+}
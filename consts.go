@@ -1,19 +1,15 @@
 package main
 
-// The default trackpoint configuration values
+import "time"
+
 const (
-	DefaultDragHysteresis = 0xFF
-	DefaultThreshold      = 0x08
-	DefaultUpThreshold    = 0xFF
-	DefaultZTime          = 0x26
-	DefaultSensitivity    = 0x80
-	DefaultInertia        = 0x06
-	DefaultSpeed          = 0x61
-	DefaultReach          = 0x0A
-	DefaultMinDrag        = 0x14
-	DefaultJenks          = 0x87
-	DefaultDriftTime      = 0x05
-	DefaultPressToSelect  = false
-	DefaultSkipback       = false
-	DefaultExternalDevice = false
+	// DefaultInterval is the default interval at which the daemon executes.
+	DefaultInterval = 30 * time.Second
+	// DefaultStateDir is the default directory for persistent state.
+	DefaultStateDir = "/var/lib/trackpoint"
+	// DefaultRunDir is the default directory for runtime files like the control socket.
+	DefaultRunDir = "/run/trackpoint"
+	// DefaultTrial is the default time a change made through the CLI is
+	// tried before it is reverted unless it is confirmed.
+	DefaultTrial = 30 * time.Second
 )
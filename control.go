@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ControlSocketName is the name of the control socket in the run directory.
+	ControlSocketName = "control.sock"
+	// NoProfile is the profile name that switches back to no runtime profile.
+	NoProfile = "none"
+)
+
+// ControlTimeout is the time a request on the control socket may take.
+var ControlTimeout = 10 * time.Second
+
+// ControlRequest is a request to the daemon on the control socket.
+type ControlRequest struct {
+	Command string        `json:"command"` // Command is "set", "profile", "rollback" or "confirm".
+	Args    []string      `json:"args"`    // Args are the arguments of the command.
+	Trial   time.Duration `json:"trial"`   // Trial is how long the change is tried before it is reverted, 0 applies it right away.
+	Source  string        `json:"source"`  // Source is recorded in the history, SourceSocket if empty.
+}
+
+// ControlResponse is the answer of the daemon to a ControlRequest.
+type ControlResponse struct {
+	Error   string `json:"error,omitempty"`   // Error describes why the request failed.
+	Message string `json:"message,omitempty"` // Message describes the result of the request.
+}
+
+// ControlSocket returns the path of the control socket.
+func (s *Settings) ControlSocket() string {
+	return filepath.Join(s.RunDir, ControlSocketName)
+}
+
+// ServeControl answers the requests on the unix socket at path until stop
+// receives a value. The socket is only accessible by the owner.
+func ServeControl(path string, handle func(*ControlRequest) *ControlResponse, stop <-chan bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// remove a stale socket of a previous run
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return err
+	}
+	stopped := make(chan bool)
+	go func() {
+		<-stop
+		close(stopped)
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-stopped:
+				return nil
+			default:
+				return err
+			}
+		}
+		go serveControlConn(conn, handle)
+	}
+}
+
+func serveControlConn(conn net.Conn, handle func(*ControlRequest) *ControlResponse) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ControlTimeout))
+	var req ControlRequest
+	var resp *ControlResponse
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		resp = &ControlResponse{Error: fmt.Sprintf("invalid request: %v", err)}
+	} else {
+		resp = handle(&req)
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// SendControl sends the request to the daemon listening on the socket at path.
+func SendControl(path string, req *ControlRequest) (*ControlResponse, error) {
+	conn, err := net.DialTimeout("unix", path, ControlTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ControlTimeout))
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp ControlResponse
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return &resp, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// handleControl handles a request on the control socket.
+func (d *SettingsDaemon) handleControl(req *ControlRequest) *ControlResponse {
+	var err error
+	source := req.Source
+	if source == "" {
+		source = SourceSocket
+	}
+	switch req.Command {
+	case "set":
+		err = d.controlSet(req.Args, req.Trial, source)
+	case "profile":
+		err = d.controlProfile(req.Args, req.Trial, source)
+	case "rollback":
+		err = d.controlRollback(req.Args, req.Trial)
+	case "confirm":
+		if len(req.Args) != 0 {
+			err = errors.New("confirm takes no arguments")
+		} else if err = d.confirm(); err == nil {
+			return &ControlResponse{Message: "confirmed"}
+		}
+	default:
+		err = fmt.Errorf("unknown command %q", req.Command)
+	}
+	if err != nil {
+		return &ControlResponse{Error: err.Error()}
+	}
+	if req.Trial > 0 {
+		return &ControlResponse{Message: fmt.Sprintf("applied, reverting in %v unless confirmed", req.Trial)}
+	}
+	return &ControlResponse{Message: "applied"}
+}
+
+func (d *SettingsDaemon) controlSet(args []string, trial time.Duration, source string) error {
+	if len(args) == 0 {
+		return errors.New("set requires at least one attribute=value")
+	}
+	values := make(Values)
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("expected attribute=value, got %q", arg)
+		}
+		if err := values.Set(kv[0], kv[1]); err != nil {
+			return fmt.Errorf("%s: %v", kv[0], err)
+		}
+	}
+	d.RLock()
+	flags := d.Settings.Flags
+	d.RUnlock()
+	if flags != nil {
+		pinned := flags.Values()
+		for key := range values {
+			if _, ok := pinned[key]; ok {
+				return fmt.Errorf("%s is set on the command line of the daemon", key)
+			}
+		}
+	}
+	err := d.try(trial, func() {
+		v := make(Values)
+		v.Apply(d.state.Values)
+		v.Apply(values)
+		d.state.Values = v
+	})
+	if err != nil {
+		return err
+	}
+	return d.applySettings(source)
+}
+
+func (d *SettingsDaemon) controlProfile(args []string, trial time.Duration, source string) error {
+	if len(args) != 1 {
+		return errors.New("profile requires exactly one profile name")
+	}
+	name := args[0]
+	if name == NoProfile {
+		name = ""
+	} else {
+		d.RLock()
+		_, ok := d.Settings.Profiles[name]
+		d.RUnlock()
+		if !ok {
+			return fmt.Errorf("unknown profile %q", name)
+		}
+	}
+	if err := d.try(trial, func() { d.state.Profile = name }); err != nil {
+		return err
+	}
+	return d.applySettings(source)
+}
+
+// controlRollback sets the values of a history entry at runtime, including
+// the extra attributes. Like the values of set they are kept until the
+// config is reloaded, which replaces them.
+func (d *SettingsDaemon) controlRollback(args []string, trial time.Duration) error {
+	if len(args) != 1 {
+		return errors.New("rollback requires exactly one history id")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid history id %q", args[0])
+	}
+	e, err := d.history.Lookup(id)
+	if err != nil {
+		return err
+	} else if e == nil {
+		return fmt.Errorf("no history entry %d", id)
+	}
+	values, extra, err := e.Settings()
+	if err != nil {
+		return err
+	}
+	err = d.try(trial, func() {
+		v, x := make(Values), make(Extra)
+		v.Apply(d.state.Values)
+		v.Apply(values)
+		for key, value := range d.state.Extra {
+			x[key] = value
+		}
+		for key, value := range extra {
+			x[key] = value
+		}
+		d.state.Values, d.state.Extra = v, x
+	})
+	if err != nil {
+		return err
+	}
+	return d.applySettings(SourceRollback)
+}
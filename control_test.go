@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestControl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run", ControlSocketName)
+
+	stop := make(chan bool)
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeControl(path, func(req *ControlRequest) *ControlResponse {
+			if req.Command != "set" {
+				return &ControlResponse{Error: "unknown command"}
+			}
+			return &ControlResponse{Message: req.Args[0] + " " + req.Trial.String()}
+		}, stop)
+	}()
+
+	var resp *ControlResponse
+	for i := 0; i < 50; i++ {
+		if resp, err = SendControl(path, &ControlRequest{Command: "set", Args: []string{"speed=1"}, Trial: time.Second}); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message != "speed=1 1s" {
+		t.Fatalf("expected %v, got %v", "speed=1 1s", resp.Message)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected socket with mode 0600, got %v, %v", info, err)
+	}
+	if _, err = SendControl(path, &ControlRequest{Command: "foo"}); err == nil || err.Error() != "unknown command" {
+		t.Fatalf("expected %v, got %v", "unknown command", err)
+	}
+
+	close(stop)
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSettingsDaemon_ControlSetReload(t *testing.T) {
+	dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128", "speed": "97"})
+	defer cleanup()
+	path, cleanupConfig := writeConfig(t, "sysfs: "+dir+"\nunset: ignore\nvalues:\n  speed: 100\n")
+	defer cleanupConfig()
+	flags, err := ParseFlags([]string{"trackpoint", "--config", path, "--state-dir", filepath.Join(filepath.Dir(path), "state"), "--sensitivity", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSettings(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewSettingsDaemon(s)
+	fakeWrites(d.rw)
+
+	if resp := d.handleControl(&ControlRequest{Command: "set", Args: []string{"sensitivity=5"}}); resp.Error == "" {
+		t.Fatal("expected error for a value set on the command line")
+	}
+	if resp := d.handleControl(&ControlRequest{Command: "set", Args: []string{"speed=120"}}); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	if v := readAttribute(t, dir, "speed"); v != "120" {
+		t.Fatalf("expected %v, got %v", "120", v)
+	}
+
+	// the config replaces the values set at runtime
+	if err = ioutil.WriteFile(path, []byte("sysfs: "+dir+"\nunset: ignore\nvalues:\n  speed: 90\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.refreshSettings(); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.applySettings(SourceReload); err != nil {
+		t.Fatal(err)
+	}
+	if v := readAttribute(t, dir, "speed"); v != "90" {
+		t.Fatalf("expected %v, got %v", "90", v)
+	}
+}
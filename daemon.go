@@ -17,7 +17,13 @@ type Daemon interface {
 	DoStuff(stop <-chan bool) error
 }
 
-// Run starts the daemon.
+// Shutdowner is a daemon that cleans up after it was stopped gracefully.
+type Shutdowner interface {
+	Shutdown() error
+}
+
+// Run starts the daemon. If it is a Shutdowner, it is shut down after it
+// stopped because of a signal.
 func Run(d Daemon) error {
 	var (
 		stop = make(chan bool, 1)
@@ -31,12 +37,19 @@ func Run(d Daemon) error {
 
 	go func() { e <- d.DoStuff(stop) }()
 
+	stopped := false
 	for {
 		select {
 		case signal := <-s:
 			log.Printf("received %v", signal)
-			stop <- true
+			if !stopped {
+				stopped = true
+				stop <- true
+			}
 		case err := <-e:
+			if sd, ok := d.(Shutdowner); ok && stopped && err == nil {
+				err = sd.Shutdown()
+			}
 			return err
 		}
 	}
@@ -45,18 +58,46 @@ func Run(d Daemon) error {
 // SettingsDaemon is a simple daemon implementation.
 type SettingsDaemon struct {
 	*sync.RWMutex
-	rw        *SettingsReaderWriter
-	Settings  *Settings
-	SysfsPath string
+	writing    sync.Mutex // writing serializes the writes to the device, taken before the RWMutex.
+	rw         *SettingsReaderWriter
+	Settings   *Settings
+	SysfsPath  string
+	OnEvent    func(Event) // OnEvent is called for every event of the daemon.
+	state      State
+	trial      *trial
+	originals  *Originals
+	history    *History
+	conflicts  *conflicts
+	overlaid   map[string]string // overlaid are the device values of the keys only the state sets, guarded by writing.
+	helper     *Helper           // helper writes the attributes if the privileges are separated.
+	pidFile    *Lock             // pidFile is the locked pidfile of the daemon.
+	deviceLock *Lock             // deviceLock is the lock of the device, nil if it is not locked.
 }
 
 // NewSettingsDaemon creates a new daemon.
 func NewSettingsDaemon(settings *Settings) (d *SettingsDaemon) {
-	return &SettingsDaemon{
-		RWMutex:  &sync.RWMutex{},
-		rw:       NewSettingsReaderWriter(settings.SysfsPath),
-		Settings: settings,
+	d = &SettingsDaemon{
+		RWMutex:   &sync.RWMutex{},
+		Settings:  settings,
+		history:   NewHistory(settings.StateDir),
+		conflicts: newConflicts(),
 	}
+	d.rw = d.newReaderWriter(settings.SysfsPath)
+	return d
+}
+
+// UseHelper lets the daemon write the attributes through the helper.
+func (d *SettingsDaemon) UseHelper(h *Helper) {
+	d.helper = h
+	d.rw.write = h.Write
+}
+
+func (d *SettingsDaemon) newReaderWriter(path string) *SettingsReaderWriter {
+	rw := NewSettingsReaderWriter(path)
+	if d.helper != nil {
+		rw.write = d.helper.Write
+	}
+	return rw
 }
 
 func (d *SettingsDaemon) watchSettings(stop <-chan bool) (changed chan bool, errors chan error) {
@@ -108,80 +149,275 @@ func (d *SettingsDaemon) watchSettings(stop <-chan bool) (changed chan bool, err
 	return
 }
 
+func (d *SettingsDaemon) emit(e Event) {
+	log.Print(e)
+	if d.OnEvent != nil {
+		d.OnEvent(e)
+	}
+}
+
 func (d *SettingsDaemon) onSettingsChange() error {
 	log.Println("settings file changed")
-	return d.refreshSettings()
+	if err := d.refreshSettings(); err != nil {
+		d.emit(newConfigEvent(EventReloadRejected, d.Settings.Path, err))
+	}
+	return nil
 }
 
+// refreshSettings loads and validates the settings and only swaps them in if
+// they are valid. Otherwise the last known good settings are kept. The
+// values set at runtime are replaced by the config. With a trial the
+// settings are reverted unless they are confirmed in time.
 func (d *SettingsDaemon) refreshSettings() error {
-	d.Lock()
-	defer d.Unlock()
 	log.Printf("refreshing settings")
-	return d.Settings.ReadYAML(d.Settings.Path)
+	settings, err := LoadSettings(d.Settings.Flags)
+	if err != nil {
+		return err
+	}
+	if err = settings.Validate(); err != nil {
+		return err
+	}
+	if settings.Trial == 0 {
+		d.saveLastKnownGood(settings)
+	}
+	err = d.try(settings.Trial, func() {
+		d.setSettings(settings)
+		d.state.Values, d.state.Extra = nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	d.conflicts.reset()
+	return nil
+}
+
+// setSettings swaps in the settings. The caller holds the lock.
+func (d *SettingsDaemon) setSettings(settings *Settings) {
+	changed := settings.SysfsPath != d.Settings.SysfsPath
+	if changed {
+		log.Printf("device changed from %v to %v", d.Settings.SysfsPath, settings.SysfsPath)
+		d.rw = d.newReaderWriter(settings.SysfsPath)
+	}
+	d.Settings = settings
+	if changed && d.deviceLock != nil {
+		if err := d.lockDevice(); err != nil {
+			log.Printf("could not lock the device: %v", err)
+		}
+	}
+}
+
+// saveLastKnownGood persists the config file of the settings if they are valid.
+func (d *SettingsDaemon) saveLastKnownGood(settings *Settings) {
+	if err := settings.Validate(); err != nil {
+		log.Printf("not persisting an invalid config as last known good: %v", err)
+		return
+	}
+	if err := settings.SaveLastKnownGood(); err != nil {
+		log.Printf("could not persist last known good config: %v", err)
+	}
+}
+
+func (d *SettingsDaemon) interval() time.Duration {
+	d.RLock()
+	defer d.RUnlock()
+	return d.Settings.Interval
 }
 
 // DoStuff does the stuff.
 func (d *SettingsDaemon) DoStuff(stop <-chan bool) (err error) {
-	err = d.applySettings()
+	d.saveLastKnownGood(d.Settings)
+	if err = d.captureOriginals(); err != nil {
+		log.Printf("could not capture the original values: %v", err)
+	}
+	d.warnManagers()
+	err = d.applySettings(SourceStartup)
 	if err != nil {
 		log.Print(err)
 		err = nil
 	}
 
-	log.Printf("Scheduling daemon at %v", d.Settings.Interval)
+	var (
+		changed <-chan bool
+		errors  <-chan error
+		stop2   = make(chan bool, 1)
+	)
+	if d.Settings.Path != "" {
+		var c chan bool
+		c, errors = d.watchSettings(stop2)
+		changed = DebounceBool(2*time.Second, c)
+	}
 
-	if d.Settings.Path == "" {
-		for {
-			select {
-			case <-stop:
-				return
-			case <-time.After(d.Settings.Interval):
-				d.applySettingsNoError()
-			}
+	hotplug := NewHotplug()
+	power := WatchPower(hotplug, stop2)
+	mouse := WatchPointingDevices(hotplug, d.device, stop2)
+	go hotplug.Run(stop2)
+	go func() {
+		if err := ServeControl(d.Settings.ControlSocket(), d.handleControl, stop2); err != nil {
+			log.Printf("control socket not available: %v", err)
 		}
-	} else {
+	}()
 
-		stop2 := make(chan bool, 1)
-		changed, errors := d.watchSettings(stop2)
-		changed = DebounceBool(2*time.Second, changed)
+	interval := d.interval()
+	log.Printf("Scheduling daemon at %v", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastTick := time.Now()
 
-		for {
-			select {
-			case <-stop:
-				stop2 <- true
-				err = nil
-				return
-			case err = <-errors:
+	for {
+		select {
+		case <-stop:
+			close(stop2)
+			return nil
+		case err = <-errors:
+			return
+		case <-changed:
+			err = d.onSettingsChange()
+			if err != nil {
 				return
-			case <-changed:
-				err = d.onSettingsChange()
-				if err != nil {
-					return
-				}
-				d.applySettingsNoError()
-			case <-time.After(d.Settings.Interval):
-				d.applySettingsNoError()
 			}
+			if i := d.interval(); i != interval {
+				interval = i
+				log.Printf("Rescheduling daemon at %v", interval)
+				ticker.Reset(interval)
+				lastTick = time.Now()
+			}
+			d.applySettingsNoError(SourceReload)
+		case p := <-power:
+			d.setPowerState(p)
+			d.applySettingsNoError(SourceHotplug)
+		case m := <-mouse:
+			d.setMouseState(m)
+			d.applySettingsNoError(SourceHotplug)
+		case now := <-ticker.C:
+			source := SourceInterval
+			if resumed(now.Round(0).Sub(lastTick.Round(0)), now.Sub(lastTick), interval) {
+				log.Printf("resumed from suspend")
+				source = SourceResume
+			}
+			lastTick = now
+			d.applySettingsNoError(source)
+		}
+	}
+}
+
+func (d *SettingsDaemon) setPowerState(p PowerState) {
+	d.Lock()
+	defer d.Unlock()
+	if p != d.state.Power {
+		log.Printf("running on %v", p)
+	}
+	d.state.Power = p
+}
+
+func (d *SettingsDaemon) setMouseState(m bool) {
+	d.Lock()
+	defer d.Unlock()
+	if m != d.state.Mouse {
+		if m {
+			log.Printf("external pointing device attached")
+		} else {
+			log.Printf("external pointing device removed")
 		}
 	}
+	d.state.Mouse = m
+}
 
+func (d *SettingsDaemon) device() *Device {
+	d.RLock()
+	defer d.RUnlock()
+	return d.Settings.Device
 }
 
-func (d *SettingsDaemon) applySettings() error {
+// applySettings writes the effective settings and records the changes with
+// the given source in the history.
+func (d *SettingsDaemon) applySettings(source string) error {
+	d.writing.Lock()
+	defer d.writing.Unlock()
 	d.RLock()
 	defer d.RUnlock()
-	return d.rw.Set(d.Settings)
+	effective := d.Settings.Effective(d.state)
+	restored := d.restoreOverlaid(effective)
+	keys := effective.DeviceKeys()
+	before, _ := d.rw.Snapshot(keys)
+	d.recordOverlaid(effective, before)
+	if err := d.rw.Set(d.conflicts.uncontested(effective)); err != nil {
+		return err
+	}
+	for _, key := range restored {
+		delete(d.overlaid, key)
+	}
+	d.conflicts.settle(d.rw)
+	d.history.Record(source, d.rw, keys, before)
+	time.AfterFunc(ConflictCheckDelay, d.checkConflicts)
+	return nil
 }
 
-func (d *SettingsDaemon) applySettingsNoError() {
-	err := d.applySettings()
-	if err != nil {
+// restoreOverlaid adds the device values from before the state set a key to
+// the effective settings for the keys it no longer sets and returns them.
+// Without it, keys that are not set in the config keep the value of an
+// overlay that went away, e.g. with unset: ignore.
+func (d *SettingsDaemon) restoreOverlaid(effective *Settings) (restored []string) {
+	base := d.Settings.Effective(State{})
+	if len(d.overlaid) > 0 {
+		extra := make(Extra)
+		for key, value := range effective.Extra {
+			extra[key] = value
+		}
+		effective.Extra = extra
+	}
+	for key, value := range d.overlaid {
+		switch {
+		case base.sets(key):
+			// the config manages the key now
+			delete(d.overlaid, key)
+		case !effective.sets(key):
+			if LookupAttribute(key) != nil {
+				effective.Values.Set(key, value)
+			} else {
+				effective.Extra[key] = value
+			}
+			restored = append(restored, key)
+		}
+	}
+	return restored
+}
+
+// recordOverlaid remembers the device values of the keys the state sets on
+// top of the config before they are first written.
+func (d *SettingsDaemon) recordOverlaid(effective *Settings, before map[string]string) {
+	base := d.Settings.Effective(State{})
+	effective.ForEach(func(key, _ string) error {
+		if _, ok := d.overlaid[key]; ok || base.sets(key) {
+			return nil
+		}
+		if value, ok := before[key]; ok {
+			if d.overlaid == nil {
+				d.overlaid = make(map[string]string)
+			}
+			d.overlaid[key] = value
+		}
+		return nil
+	})
+}
+
+func (d *SettingsDaemon) applySettingsNoError(source string) {
+	err := d.applySettings(source)
+	if e, ok := err.(*ApplyError); ok {
+		d.emit(Event{Type: EventApplyFailed, Time: time.Now(), Message: e.Error()})
+	} else if err != nil {
 		log.Print(err)
 	}
 }
 
 func (d *SettingsDaemon) applySetting(key string) error {
+	d.writing.Lock()
+	defer d.writing.Unlock()
 	d.RLock()
 	defer d.RUnlock()
-	return d.rw.SetValue(key, d.Settings.Get(key))
+	value := d.Settings.Effective(d.state).Get(key)
+	if value == "" {
+		// the key is not managed
+		return nil
+	}
+	return d.rw.SetValue(key, value)
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// DetachedEnv holds the path of the log file in the environment of the
+	// detached daemon.
+	DetachedEnv = "TRACKPOINT_DETACHED"
+	// DefaultLogFile is the log file of the detached daemon if none is given.
+	DefaultLogFile = "/var/log/trackpoint.log"
+	// DetachTimeout is the time the detached daemon has to create its pidfile.
+	DetachTimeout = 10 * time.Second
+	// StopTimeout is the default time the stop command waits for the daemon to exit.
+	StopTimeout = 30 * time.Second
+)
+
+// Detached returns whether this process is the detached daemon.
+func Detached() bool {
+	return os.Getenv(DetachedEnv) != ""
+}
+
+// pathFlags are the flags holding paths, which are made absolute for the
+// detached daemon.
+var pathFlags = []string{"config", "c", "sysfs", "state-dir", "run-dir", "models-dir", "log-file"}
+
+// Detach starts the daemon again in a new session without a terminal in the
+// root directory and returns once it created its pidfile.
+func Detach(settings *Settings) (pid int, err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	args, err := absArgs(os.Args[1:])
+	if err != nil {
+		return 0, err
+	}
+	logFile := settings.LogFile
+	if logFile == "" {
+		logFile = DefaultLogFile
+	}
+	if logFile, err = filepath.Abs(logFile); err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), DetachedEnv+"="+logFile)
+	// the daemon does not keep the directory it was started in busy
+	cmd.Dir = "/"
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = cmd.Start(); err != nil {
+		return 0, err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	pidFile := filepath.Join(settings.RunDir, PidFileName)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(DetachTimeout)
+	for {
+		select {
+		case err = <-exited:
+			return 0, fmt.Errorf("the daemon exited (%v), see %s", err, logFile)
+		case <-timeout:
+			return cmd.Process.Pid, fmt.Errorf("the daemon did not create %s in %v", pidFile, DetachTimeout)
+		case <-ticker.C:
+			if p, _ := ReadPid(pidFile); p == cmd.Process.Pid {
+				return p, nil
+			}
+		}
+	}
+}
+
+// absArgs makes the paths given to the pathFlags absolute.
+func absArgs(args []string) ([]string, error) {
+	abs := append([]string(nil), args...)
+	for i := 0; i < len(abs) && abs[i] != "--"; i++ {
+		name := strings.TrimLeft(abs[i], "-")
+		if name == abs[i] {
+			continue
+		}
+		j, value := i, ""
+		if k := strings.IndexByte(name, '='); k >= 0 {
+			// -flag=value
+			name, value = name[:k], name[k+1:]
+		} else if contains(pathFlags, name) && i+1 < len(abs) {
+			// -flag value
+			j, value = i+1, abs[i+1]
+		}
+		if !contains(pathFlags, name) || value == "" {
+			continue
+		}
+		path, err := filepath.Abs(value)
+		if err != nil {
+			return nil, err
+		}
+		abs[j] = strings.TrimSuffix(abs[j], value) + path
+		i = j
+	}
+	return abs, nil
+}
+
+// logFile returns the log file. The detached daemon uses the one its parent
+// chose, which defaults to DefaultLogFile.
+func (s *Settings) logFile() string {
+	if Detached() {
+		return os.Getenv(DetachedEnv)
+	}
+	return s.LogFile
+}
+
+// LogFile is the file the output of the process goes to.
+type LogFile struct {
+	Path string // Path is the path of the log file.
+}
+
+// OpenLogFile redirects stdout, stderr and the log to the file at path.
+func OpenLogFile(path string) (*LogFile, error) {
+	l := &LogFile{Path: path}
+	return l, l.Reopen()
+}
+
+// Reopen opens the file again, e.g. after it was rotated.
+func (l *LogFile) Reopen() error {
+	f, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, fd := range []int{1, 2} {
+		if err = dup2(int(f.Fd()), fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReopenOnSignal reopens the file whenever the process receives SIGUSR2.
+func (l *LogFile) ReopenOnSignal() {
+	s := make(chan os.Signal, 1)
+	signal.Notify(s, syscall.SIGUSR2)
+	go func() {
+		for range s {
+			if err := l.Reopen(); err != nil {
+				log.Printf("could not reopen %s: %v", l.Path, err)
+			} else {
+				log.Printf("reopened %s", l.Path)
+			}
+		}
+	}()
+}
+
+// StopDaemon signals the daemon with the pidfile in runDir to stop and waits
+// for it to exit.
+func StopDaemon(runDir string, timeout time.Duration) (int, error) {
+	path := filepath.Join(runDir, PidFileName)
+	pid, locked, err := ProbeLock(path)
+	if err != nil {
+		return 0, err
+	} else if !locked {
+		return 0, fmt.Errorf("no daemon is running with the pidfile in %s", runDir)
+	} else if pid == 0 {
+		return 0, fmt.Errorf("%s holds no pid", path)
+	}
+	if err = syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return pid, err
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if err = syscall.Kill(pid, 0); err == syscall.ESRCH {
+			return pid, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return pid, fmt.Errorf("the daemon (pid %d) did not stop in %v", pid, timeout)
+}
@@ -0,0 +1,9 @@
+package main
+
+import "syscall"
+
+// dup2 makes newfd a copy of oldfd. Some architectures lack dup2, so it
+// uses dup3.
+func dup2(oldfd, newfd int) error {
+	return syscall.Dup3(oldfd, newfd, 0)
+}
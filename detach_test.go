@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// keep the output of the test
+	for _, fd := range []int{1, 2} {
+		saved, err := syscall.Dup(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer syscall.Close(saved)
+		defer dup2(saved, fd)
+	}
+
+	path := filepath.Join(dir, "trackpoint.log")
+	l, err := OpenLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(os.Stderr, "before")
+	if err = os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(os.Stdout, "after")
+
+	for file, expected := range map[string]string{path + ".1": "before\n", path: "after\n"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Fatalf("expected %q in %v, got %q", expected, file, data)
+		}
+	}
+}
+
+func TestLogFileDefault(t *testing.T) {
+	s := newSettings()
+	if path := s.logFile(); path != "" {
+		t.Fatalf("expected no log file, got %v", path)
+	}
+	s.LogFile = "trackpoint.log"
+	os.Setenv(DetachedEnv, DefaultLogFile)
+	defer os.Unsetenv(DetachedEnv)
+	if path := s.logFile(); path != DefaultLogFile {
+		t.Fatalf("expected %v, got %v", DefaultLogFile, path)
+	}
+}
+
+func TestAbsArgs(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"--detach", "-c", "trackpoint.yml", "--log-file=log/trackpoint.log", "--user", "nobody",
+		"--state-dir", "/var/lib/trackpoint", "--run-dir=", "--sysfs", "serio2", "--", "--models-dir", "models"}
+	expected := []string{"--detach", "-c", filepath.Join(wd, "trackpoint.yml"), "--log-file=" + filepath.Join(wd, "log/trackpoint.log"),
+		"--user", "nobody", "--state-dir", "/var/lib/trackpoint", "--run-dir=", "--sysfs", filepath.Join(wd, "serio2"), "--", "--models-dir", "models"}
+	abs, err := absArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(abs) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, abs)
+	}
+}
+
+func TestStopDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err = StopDaemon(dir, time.Second); err == nil || !strings.Contains(err.Error(), "no daemon") {
+		t.Fatalf("expected error without a daemon, got %v", err)
+	}
+
+	// a process holding the locked pidfile like the daemon
+	pidFile := filepath.Join(dir, PidFileName)
+	cmd := exec.Command("sh", "-c", `exec 9>>"$1"; flock 9; echo $$ > "$1"; exec sleep 30`, "sh", pidFile)
+	if err = cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	for i := 0; ; i++ {
+		if pid, _ := ReadPid(pidFile); pid == cmd.Process.Pid {
+			break
+		} else if i == 50 {
+			cmd.Process.Kill()
+			t.Skip("the pidfile was not locked, flock is missing")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	pid, err := StopDaemon(dir, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid != cmd.Process.Pid {
+		t.Fatalf("expected %v, got %v", cmd.Process.Pid, pid)
+	}
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("expected the process to exit")
+	}
+}
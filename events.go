@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType is the type of a daemon event.
+type EventType string
+
+const (
+	// EventReloadRejected indicates that a changed config was rejected and the
+	// last known good settings are kept.
+	EventReloadRejected EventType = "reload rejected"
+	// EventApplyFailed indicates that values could not be written to the device.
+	EventApplyFailed EventType = "apply failed"
+	// EventConflict indicates that something else writes the attributes too.
+	EventConflict EventType = "conflict"
+	// EventTrialStarted indicates that a change is tried and will be reverted
+	// unless it is confirmed.
+	EventTrialStarted EventType = "trial started"
+	// EventTrialConfirmed indicates that the changes of a trial were confirmed.
+	EventTrialConfirmed EventType = "trial confirmed"
+	// EventTrialReverted indicates that the changes of a trial were not
+	// confirmed in time and the previous values were restored.
+	EventTrialReverted EventType = "trial reverted"
+)
+
+// Event is something noteworthy that happened in the daemon.
+type Event struct {
+	Type    EventType // Type is the type of the event.
+	Time    time.Time // Time is the time the event occurred.
+	Message string    // Message describes the event.
+	File    string    // File is the config file the event refers to, if any.
+	Line    int       // Line is the line in File, 0 if unknown.
+	Column  int       // Column is the column in File, 0 if unknown.
+}
+
+func (e Event) String() string {
+	if e.File == "" {
+		return fmt.Sprintf("%s: %s", e.Type, e.Message)
+	}
+	return fmt.Sprintf("%s: %s:%d:%d: %s", e.Type, e.File, e.Line, e.Column, e.Message)
+}
+
+// newConfigEvent creates an event for err, positioned at the first config error.
+func newConfigEvent(t EventType, file string, err error) Event {
+	e := Event{Type: t, Time: time.Now(), Message: err.Error(), File: file}
+	switch err := err.(type) {
+	case ConfigErrors:
+		if len(err) > 0 {
+			e.File, e.Line, e.Column, e.Message = err[0].File, err[0].Line, err[0].Column, err[0].Msg
+		}
+		if len(err) > 1 {
+			e.Message += fmt.Sprintf(" (and %d more)", len(err)-1)
+		}
+	case *ConfigError:
+		e.File, e.Line, e.Column, e.Message = err.File, err.Line, err.Column, err.Msg
+	}
+	return e
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Exporters write the settings in formats that apply them without the daemon.
+var Exporters = map[string]func(s *Settings, w io.Writer) error{
+	"udev":     ExportUdev,
+	"tmpfiles": ExportTmpfiles,
+	"script":   ExportScript,
+	"quirks":   ExportQuirks,
+	"hwdb":     ExportHwdb,
+}
+
+// ExporterNames returns the names of the export formats.
+func ExporterNames() []string {
+	var names []string
+	for name := range Exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// exportValues returns the values and the extra attributes of the settings
+// in the order they are written. Attributes the variant of the device does
+// not support are left out.
+func exportValues(s *Settings) (keys, values []string) {
+	s.ForEach(func(key, value string) error {
+		if s.Device == nil || LookupAttribute(key) == nil || s.Device.Variant.Supports(key) {
+			keys, values = append(keys, key), append(values, value)
+		}
+		return nil
+	})
+	return keys, values
+}
+
+// ExportUdev writes a udev rule that sets the values when the TrackPoint is
+// added. The rule matches the input device by its name like FindDevice and
+// sets the attributes of its parent, the serio device.
+func ExportUdev(s *Settings, w io.Writer) error {
+	name := "*" + TrackPointName + "*"
+	if s.Device != nil && s.Device.Name != "" {
+		name = s.Device.Name
+	}
+	keys, values := exportValues(s)
+	if len(keys) == 0 {
+		return fmt.Errorf("no values to export")
+	}
+	lines := []string{fmt.Sprintf(`ACTION=="add", SUBSYSTEM=="input", ATTR{name}==%s`, udevQuote(name))}
+	for i, key := range keys {
+		lines = append(lines, fmt.Sprintf("  ATTR{device/%s}=%s", key, udevQuote(values[i])))
+	}
+	_, err := fmt.Fprintf(w, "%s\n%s\n", exportHeader(s, "#"), strings.Join(lines, ", \\\n"))
+	return err
+}
+
+// exportHeader returns a comment describing where the export came from.
+func exportHeader(s *Settings, comment string) string {
+	source := "the defaults"
+	if s.Path != "" {
+		source = s.Path
+	}
+	return fmt.Sprintf("%s TrackPoint settings exported by trackpoint from %s.", comment, source)
+}
+
+// ExportTmpfiles writes a systemd-tmpfiles config that writes the values to
+// the attributes of the device at SysfsPath during boot.
+func ExportTmpfiles(s *Settings, w io.Writer) error {
+	if s.SysfsPath == "" {
+		return fmt.Errorf("the path of the device is unknown")
+	}
+	keys, values := exportValues(s)
+	if len(keys) == 0 {
+		return fmt.Errorf("no values to export")
+	}
+	lines := []string{exportHeader(s, "#")}
+	for i, key := range keys {
+		lines = append(lines, fmt.Sprintf("w %s - - - - %s", filepath.Join(s.SysfsPath, key), values[i]))
+	}
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+var scriptTemplate = template.Must(template.New("script").Parse(`#!/bin/sh
+{{.Header}}
+# Waits for the TrackPoint like trackpoint does and writes the values.
+set -u
+
+base={{.Base}}
+device=
+attempt=1
+while :; do
+	for name in $(find "$base" -type f -name name 2>/dev/null); do
+		if grep -q {{.Name}} "$name"; then
+			device=$(dirname "$(dirname "$(dirname "$name")")")
+			break 2
+		fi
+	done
+	if [ "$attempt" -ge {{.Attempts}} ]; then
+		echo "device directory not found" >&2
+		exit 1
+	fi
+	attempt=$((attempt + 1))
+	sleep 1
+done
+
+write() {
+	if [ -f "$device/$1" ]; then
+		printf '%s' "$2" > "$device/$1" || echo "$1: could not write $2" >&2
+	else
+		echo "$1: not supported by the device, skipping" >&2
+	fi
+}
+
+{{range .Values}}write {{.Key}} {{.Value}}
+{{end}}`))
+
+// ExportScript writes a POSIX shell script that waits for the TrackPoint
+// and writes the values.
+func ExportScript(s *Settings, w io.Writer) error {
+	keys, values := exportValues(s)
+	if len(keys) == 0 {
+		return fmt.Errorf("no values to export")
+	}
+	type value struct{ Key, Value string }
+	data := struct {
+		Header, Base, Name string
+		Attempts           int
+		Values             []value
+	}{
+		Header:   exportHeader(s, "#"),
+		Base:     shellQuote(SysfsBaseDir),
+		Name:     shellQuote(TrackPointName),
+		Attempts: 10,
+	}
+	for i, key := range keys {
+		data.Values = append(data.Values, value{shellQuote(key), shellQuote(values[i])})
+	}
+	return scriptTemplate.Execute(w, data)
+}
+
+// shellQuote quotes s for the shell unless it is a plain word.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-./") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func udevQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
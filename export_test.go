@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// exportSettings are the settings exported to the fixtures in testdata.
+func exportSettings() *Settings {
+	s := newSettings()
+	s.Path = "/etc/trackpoint.yml"
+	s.SysfsPath = "/sys/devices/platform/i8042/serio1/serio2"
+	s.Values = Values{"sensitivity": 200, "speed": 97, "press_to_select": 1, "drift_time": 5}
+	s.Extra = Extra{"new_thing": "3"}
+	s.Device = &Device{Name: "TPPS/2 Elan TrackPoint", Variant: VariantElan}
+	return s
+}
+
+// checkExport compares the export of the settings to a fixture.
+func checkExport(t *testing.T, s *Settings, format, fixture string) {
+	expected, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err = Exporters[format](s, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != string(expected) {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, b.String())
+	}
+}
+
+func TestExportUdev(t *testing.T) {
+	checkExport(t, exportSettings(), "udev", "testdata/udev/export.rules")
+}
+
+func TestExportTmpfiles(t *testing.T) {
+	checkExport(t, exportSettings(), "tmpfiles", "testdata/tmpfiles/export.conf")
+}
+
+func TestExportScript(t *testing.T) {
+	checkExport(t, exportSettings(), "script", "testdata/script/export.sh")
+}
@@ -5,23 +5,22 @@ import (
 )
 
 func TestParseFlags(t *testing.T) {
-	s := NewSettings()
-	v := s.Values
-	var (
-		daemon bool
-		config string
-		err    error
-	)
-
-	err = ParseFlags([]string{"trackpoint"}, s)
+	flags, err := ParseFlags([]string{"trackpoint", "--sysfs", "/dev/null"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSettings(flags)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if daemon {
-		t.Fatalf("expected %v, got %v", false, daemon)
+	if s.Daemon {
+		t.Fatalf("expected %v, got %v", false, s.Daemon)
+	}
+	if flags.Config != "" {
+		t.Fatalf("expected %v, got %v", "", flags.Config)
 	}
-	if config != "" {
-		t.Fatalf("expected %v, got %v", "", config)
+	if s.Interval != DefaultInterval {
+		t.Fatalf("expected %v, got %v", DefaultInterval, s.Interval)
 	}
 
 	checkDefaults(s.Values, t)
@@ -30,6 +29,7 @@ func TestParseFlags(t *testing.T) {
 		"--daemon",
 		"--skipback",
 		"--extdev",
+		"--sysfs", "/dev/null",
 		"--config", "./trackpoint.yml",
 		"--draghys", "0",
 		"--thresh", "0",
@@ -45,65 +45,88 @@ func TestParseFlags(t *testing.T) {
 		"--pts", "0",
 	}
 
-	err = ParseFlags(args, s)
-
+	flags, err = ParseFlags(args)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if config != "./trackpoint.yml" {
+	s, err = LoadSettings(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := s.Values
+	if flags.Config != "./trackpoint.yml" || s.Path != "./trackpoint.yml" {
 		t.Fatal("config not read")
 	}
-	if !daemon {
+	if !s.Daemon {
 		t.Fatal("daemon not read")
 	}
-	if v.DragHysteresis != 0 {
+	if v["draghys"] != 0 {
 		t.Fatal("draghys not read")
 	}
-	if v.Threshold != 0 {
+	if v["thresh"] != 0 {
 		t.Fatal("thresh not read")
 	}
-	if v.UpThreshold != 0 {
+	if v["upthresh"] != 0 {
 		t.Fatal("upthresh not read")
 	}
-	if v.ZTime != 0 {
+	if v["ztime"] != 0 {
 		t.Fatal("ztime not read")
 	}
-	if v.Sensitivity != 0 {
+	if v["sensitivity"] != 0 {
 		t.Fatal("sensitivity not read")
 	}
-	if v.Inertia != 0 {
+	if v["inertia"] != 0 {
 		t.Fatal("inertia not read")
 	}
-	if v.Speed != 0 {
+	if v["speed"] != 0 {
 		t.Fatal("speed not read")
 	}
-	if v.Reach != 0 {
+	if v["reach"] != 0 {
 		t.Fatal("reach not read")
 	}
-	if v.MinDrag != 0 {
+	if v["mindrag"] != 0 {
 		t.Fatal("mindrag not read")
 	}
-	if v.Jenks != 0 {
+	if v["jenks"] != 0 {
 		t.Fatal("jenks not read")
 	}
-	if v.DriftTime != 0 {
+	if v["drift_time"] != 0 {
 		t.Fatal("drifttime not read")
 	}
-	if v.PressToSelect != true {
+	if v["press_to_select"] != 1 {
 		t.Fatal("pts not read")
 	}
-	if v.Skipback != true {
+	if v["skipback"] != 1 {
 		t.Fatal("skipback not read")
 	}
-	if v.ExternalDevice != true {
+	if v["ext_dev"] != 1 {
 		t.Fatal("extdev")
 	}
 
 	args = []string{"trackpoint", "--config", "./trackpoint-asdf.yml"}
 
-	err = ParseFlags(args, s)
-	if err == nil {
+	flags, err = ParseFlags(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadSettings(flags); err == nil {
 		t.Fatal("expected error")
 	}
 
 }
+
+func TestParseFlagsWait(t *testing.T) {
+	flags, err := ParseFlags([]string{"trackpoint", "--wait"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flags.Wait() {
+		t.Fatal("expected to wait")
+	}
+	if flags, err = ParseFlags([]string{"trackpoint", "--fail-if-locked"}); err != nil || flags.Wait() {
+		t.Fatalf("expected not to wait, got %v", err)
+	}
+	if _, err = ParseFlags([]string{"trackpoint", "--wait", "--fail-if-locked"}); err == nil {
+		t.Fatal("expected error for both --wait and --fail-if-locked")
+	}
+}
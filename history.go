@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The sources of the changes recorded in the history.
+const (
+	SourceStartup  = "startup"  // SourceStartup is the first apply of the daemon or a single run.
+	SourceReload   = "reload"   // SourceReload is a reload of the config file.
+	SourceCLI      = "cli"      // SourceCLI is a command of the tool sent to the daemon.
+	SourceSocket   = "socket"   // SourceSocket is another client of the control socket.
+	SourceHotplug  = "hotplug"  // SourceHotplug is a change of the power supply or the input devices.
+	SourceResume   = "resume"   // SourceResume is the first apply after the machine resumed.
+	SourceInterval = "interval" // SourceInterval is the periodic apply of the daemon.
+	SourceTrial    = "trial"    // SourceTrial is the revert of a change that was not confirmed.
+	SourceRollback = "rollback" // SourceRollback is a rollback to a previous state.
+	SourceExit     = "exit"     // SourceExit is the restore of the original values on exit.
+)
+
+// Change is the change of an attribute.
+type Change struct {
+	Key string `json:"key"` // Key is the name of the attribute.
+	Old string `json:"old"` // Old is the value before the change.
+	New string `json:"new"` // New is the value after the change.
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Key, c.Old, c.New)
+}
+
+// HistoryEntry records an apply that changed the device.
+type HistoryEntry struct {
+	ID      int               `json:"id"`      // ID identifies the entry.
+	Time    time.Time         `json:"time"`    // Time is the time of the apply.
+	Source  string            `json:"source"`  // Source is what caused the apply.
+	Device  string            `json:"device"`  // Device is the SYSFS path of the device.
+	Changes []Change          `json:"changes"` // Changes are the changed attributes.
+	Values  map[string]string `json:"values"`  // Values are all values of the device after the apply.
+}
+
+// Settings splits the values of the entry into the known attributes and the
+// extra attributes, as a rollback writes them.
+func (e *HistoryEntry) Settings() (Values, Extra, error) {
+	values, extra := make(Values), make(Extra)
+	for key, value := range e.Values {
+		if err := checkKey(key); err != nil {
+			return nil, nil, err
+		} else if LookupAttribute(key) == nil {
+			extra[key] = value
+		} else if err := values.Set(key, value); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return values, extra, nil
+}
+
+// MaxHistoryEntries is the default number of entries the history keeps.
+const MaxHistoryEntries = 1000
+
+// History is the append-only history of the changes in the state directory.
+// The oldest entries are dropped once it has more than MaxEntries.
+type History struct {
+	sync.Mutex
+	Path       string // Path is the path of the history file.
+	MaxEntries int    // MaxEntries is the number of entries that are kept.
+	nextID     int
+	count      int
+}
+
+// NewHistory creates a History in the state directory.
+func NewHistory(stateDir string) *History {
+	return &History{Path: filepath.Join(stateDir, "history.jsonl"), MaxEntries: MaxHistoryEntries}
+}
+
+// Read reads the entries of the history, oldest first.
+func (h *History) Read() ([]*HistoryEntry, error) {
+	f, err := openStateFile(h.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []*HistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var e HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", h.Path, line, err)
+		}
+		entries = append(entries, &e)
+	}
+	return entries, scanner.Err()
+}
+
+// Lookup returns the entry with the given ID or nil.
+func (h *History) Lookup(id int) (*HistoryEntry, error) {
+	entries, err := h.Read()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
+
+// Append assigns the next ID to the entry and appends it to the history.
+func (h *History) Append(e *HistoryEntry) error {
+	h.Lock()
+	defer h.Unlock()
+	if h.nextID == 0 {
+		entries, err := h.Read()
+		if err != nil {
+			return err
+		}
+		h.nextID, h.count = 1, len(entries)
+		if len(entries) > 0 {
+			h.nextID = entries[len(entries)-1].ID + 1
+		}
+	}
+	e.ID = h.nextID
+	bytes, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(h.Path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(bytes, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	h.nextID++
+	h.count++
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if h.MaxEntries > 0 && h.count > h.MaxEntries {
+		return h.truncate()
+	}
+	return nil
+}
+
+// truncate drops the oldest entries beyond MaxEntries. The caller holds the
+// lock.
+func (h *History) truncate() error {
+	entries, err := h.Read()
+	if err != nil {
+		return err
+	}
+	if len(entries) > h.MaxEntries {
+		entries = entries[len(entries)-h.MaxEntries:]
+	}
+	var buf []byte
+	for _, e := range entries {
+		bytes, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, bytes...), '\n')
+	}
+	if err = ioutil.WriteFile(h.Path+".tmp", buf, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(h.Path+".tmp", h.Path); err != nil {
+		return err
+	}
+	h.count = len(entries)
+	return nil
+}
+
+// Record appends the changes of the device since before to the history.
+// Applies that did not change anything are not recorded.
+func (h *History) Record(source string, rw *SettingsReaderWriter, keys []string, before map[string]string) {
+	after, err := rw.Snapshot(keys)
+	if err != nil {
+		log.Printf("could not record the history: %v", err)
+		return
+	}
+	var changes []Change
+	for _, key := range keys {
+		if value, ok := after[key]; ok && value != before[key] {
+			changes = append(changes, Change{Key: key, Old: before[key], New: value})
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+	e := &HistoryEntry{Time: time.Now(), Source: source, Device: rw.SysfsPath, Changes: changes, Values: after}
+	if err = h.Append(e); err != nil {
+		log.Printf("could not record the history: %v", err)
+	}
+}
+
+// resumed checks if the machine was suspended between two ticks of an
+// interval, given the time passed between them on the wall clock and on the
+// monotonic clock. The monotonic clock stops during suspend, the wall clock
+// does not.
+func resumed(wall, monotonic, interval time.Duration) bool {
+	return wall-monotonic > interval
+}
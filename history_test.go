@@ -0,0 +1,155 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128", "speed": "97"})
+	defer cleanup()
+	d := newTestDaemon(t, dir)
+
+	set := func(source string, args ...string) {
+		if resp := d.handleControl(&ControlRequest{Command: "set", Args: args, Source: source}); resp.Error != "" {
+			t.Fatal(resp.Error)
+		}
+	}
+	set(SourceCLI, "sensitivity=200", "speed=100")
+	set("", "speed=110")
+	set(SourceCLI, "speed=110")
+
+	// a new History reads the IDs from the file
+	h := NewHistory(filepath.Join(dir, "state"))
+	entries, err := h.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected %v entries, got %v", 2, len(entries))
+	}
+	e := entries[0]
+	if e.ID != 1 || e.Source != SourceCLI || len(e.Changes) != 2 || e.Device != dir {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if c := e.Changes[0].String(); c != "sensitivity: 128 -> 200" {
+		t.Fatalf("expected %v, got %v", "sensitivity: 128 -> 200", c)
+	}
+	if e = entries[1]; e.ID != 2 || e.Source != SourceSocket || e.Values["speed"] != "110" || e.Values["sensitivity"] != "200" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if err = h.Append(&HistoryEntry{Source: SourceCLI}); err != nil {
+		t.Fatal(err)
+	}
+	if e, err = h.Lookup(3); err != nil || e == nil || e.Source != SourceCLI {
+		t.Fatalf("expected entry 3, got %+v, %v", e, err)
+	}
+	d.history = h
+
+	if resp := d.handleControl(&ControlRequest{Command: "rollback", Args: []string{"1"}}); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	if v := readAttribute(t, dir, "speed"); v != "100" {
+		t.Fatalf("expected %v, got %v", "100", v)
+	}
+	if e, _ = h.Lookup(4); e == nil || e.Source != SourceRollback {
+		t.Fatalf("expected a rollback entry, got %+v", e)
+	}
+	if resp := d.handleControl(&ControlRequest{Command: "rollback", Args: []string{"42"}}); resp.Error == "" {
+		t.Fatal("expected error for unknown entry")
+	}
+}
+
+func TestHistory_RollbackExtra(t *testing.T) {
+	dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128", "new_thing": "3"})
+	defer cleanup()
+	d := newTestDaemon(t, dir)
+	if err := d.history.Append(&HistoryEntry{Source: SourceCLI, Values: map[string]string{"sensitivity": "150", "new_thing": "5"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := d.handleControl(&ControlRequest{Command: "rollback", Args: []string{"1"}}); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	expected := map[string]string{"sensitivity": "150", "new_thing": "5"}
+	for key, value := range expected {
+		if v := readAttribute(t, dir, key); v != value {
+			t.Fatalf("expected %v for %v, got %v", value, key, v)
+		}
+	}
+	if e, _ := d.history.Lookup(2); e == nil || len(e.Changes) != 2 {
+		t.Fatalf("expected a rollback entry with 2 changes, got %+v", e)
+	}
+}
+
+func TestHistory_RollbackReload(t *testing.T) {
+	dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128", "speed": "97"})
+	defer cleanup()
+	path, cleanupConfig := writeConfig(t, "sysfs: "+dir+"\nunset: ignore\nvalues:\n  speed: 100\n")
+	defer cleanupConfig()
+	flags, err := ParseFlags([]string{"trackpoint", "--config", path, "--state-dir", filepath.Join(filepath.Dir(path), "state")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSettings(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewSettingsDaemon(s)
+	fakeWrites(d.rw)
+	if err = d.history.Append(&HistoryEntry{Source: SourceCLI, Values: map[string]string{"sensitivity": "150", "speed": "80"}}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := d.handleControl(&ControlRequest{Command: "rollback", Args: []string{"1"}}); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	if v := readAttribute(t, dir, "speed"); v != "80" {
+		t.Fatalf("expected %v, got %v", "80", v)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("sysfs: "+dir+"\nunset: ignore\nvalues:\n  speed: 90\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.refreshSettings(); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.applySettings(SourceReload); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"sensitivity": "128", "speed": "90"}
+	for key, value := range expected {
+		if v := readAttribute(t, dir, key); v != value {
+			t.Fatalf("expected %v for %v, got %v", value, key, v)
+		}
+	}
+}
+
+func TestHistory_MaxEntries(t *testing.T) {
+	dir, cleanup := fakeDevice(t, nil)
+	defer cleanup()
+	h := NewHistory(dir)
+	h.MaxEntries = 3
+	for i := 0; i < 5; i++ {
+		if err := h.Append(&HistoryEntry{Source: SourceCLI}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := NewHistory(dir).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].ID != 3 || entries[2].ID != 5 {
+		t.Fatalf("expected entries 3 to 5, got %+v", entries)
+	}
+}
+
+func TestResumed(t *testing.T) {
+	if resumed(30*time.Second, 30*time.Second, 30*time.Second) {
+		t.Fatal("expected no resume")
+	}
+	if !resumed(time.Hour, 30*time.Second, 30*time.Second) {
+		t.Fatal("expected resume")
+	}
+}
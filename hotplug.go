@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Uevent is a kernel uevent.
+type Uevent struct {
+	Action    string            // Action is the action, e.g. "add", "remove" or "change".
+	DevPath   string            // DevPath is the path of the device below /sys.
+	Subsystem string            // Subsystem is the subsystem of the device.
+	Env       map[string]string // Env are all properties of the event.
+}
+
+// ParseUevent parses a kernel uevent message like "add@/devices/...\0ACTION=add\0...".
+func ParseUevent(msg []byte) (*Uevent, bool) {
+	parts := bytes.Split(msg, []byte{0})
+	if len(parts) < 2 || !bytes.Contains(parts[0], []byte("@")) {
+		return nil, false
+	}
+	e := &Uevent{Env: make(map[string]string)}
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(string(p), "=", 2)
+		if len(kv) == 2 {
+			e.Env[kv[0]] = kv[1]
+		}
+	}
+	e.Action, e.DevPath, e.Subsystem = e.Env["ACTION"], e.Env["DEVPATH"], e.Env["SUBSYSTEM"]
+	return e, e.Action != ""
+}
+
+// Hotplug distributes kernel uevents to subscribers by subsystem. If the
+// uevent socket is not available, subscribers only see their polling.
+type Hotplug struct {
+	sync.Mutex
+	subscribers map[string][]chan *Uevent
+}
+
+// NewHotplug creates a new Hotplug.
+func NewHotplug() *Hotplug {
+	return &Hotplug{subscribers: make(map[string][]chan *Uevent)}
+}
+
+// Subscribe returns a channel receiving the uevents of the subsystem.
+// Events are dropped if the subscriber is not ready to receive them.
+func (h *Hotplug) Subscribe(subsystem string) <-chan *Uevent {
+	h.Lock()
+	defer h.Unlock()
+	c := make(chan *Uevent, 16)
+	h.subscribers[subsystem] = append(h.subscribers[subsystem], c)
+	return c
+}
+
+// Publish sends the event to the subscribers of its subsystem.
+func (h *Hotplug) Publish(e *Uevent) {
+	h.Lock()
+	defer h.Unlock()
+	for _, c := range h.subscribers[e.Subsystem] {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// Run listens for kernel uevents until stop receives a value.
+func (h *Hotplug) Run(stop <-chan bool) {
+	fd, err := openUeventSocket()
+	if err != nil {
+		log.Printf("uevents not available, falling back to polling: %v", err)
+		return
+	}
+	defer syscall.Close(fd)
+	buf := make([]byte, 16*1024)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			log.Printf("error reading uevents, falling back to polling: %v", err)
+			return
+		}
+		if e, ok := ParseUevent(buf[:n]); ok {
+			h.Publish(e)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// openUeventSocket opens a netlink socket receiving the kernel uevents.
+func openUeventSocket() (int, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC,
+		syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return -1, err
+	}
+	// wake up regularly to check if we should stop
+	tv := syscall.NsecToTimeval(int64(time.Second))
+	if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err == nil {
+		err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1})
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
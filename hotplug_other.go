@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// openUeventSocket fails, uevents need Linux.
+func openUeventSocket() (int, error) {
+	return -1, errors.New("not supported on this system")
+}
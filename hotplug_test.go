@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestParseUevent(t *testing.T) {
+	msg := "change@/devices/LNXSYSTM:00/ACPI0003:00/power_supply/AC\x00" +
+		"ACTION=change\x00DEVPATH=/devices/LNXSYSTM:00/ACPI0003:00/power_supply/AC\x00" +
+		"SUBSYSTEM=power_supply\x00POWER_SUPPLY_ONLINE=1\x00SEQNUM=4242\x00"
+	e, ok := ParseUevent([]byte(msg))
+	if !ok {
+		t.Fatal("expected uevent")
+	}
+	if e.Action != "change" || e.Subsystem != "power_supply" ||
+		e.DevPath != "/devices/LNXSYSTM:00/ACPI0003:00/power_supply/AC" {
+		t.Fatalf("unexpected uevent %+v", e)
+	}
+	if e.Env["POWER_SUPPLY_ONLINE"] != "1" {
+		t.Fatalf("expected %v, got %v", "1", e.Env["POWER_SUPPLY_ONLINE"])
+	}
+
+	// udev rebroadcasts events with a binary header
+	if _, ok = ParseUevent([]byte("libudev\x00\xfe\xed")); ok {
+		t.Fatal("expected no uevent")
+	}
+}
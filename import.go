@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Assignment is a value assigned to an attribute, found by an importer.
+type Assignment struct {
+	Key    string // Key is the name of the attribute.
+	Value  string // Value is the assigned value.
+	Source string // Source is the file and the line of the assignment.
+}
+
+// Importers read the assignments of a file written by an exporter, by hand
+// or by another tool. The name of the file is used for the sources.
+var Importers = map[string]func(r io.Reader, name string) ([]Assignment, error){
+	"udev":     ImportUdev,
+	"tmpfiles": ImportTmpfiles,
+	"script":   ImportScript,
+}
+
+// ImporterNames returns the names of the import formats.
+func ImporterNames() []string {
+	var names []string
+	for name := range Importers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ImportSource is a place where TrackPoint settings are commonly kept.
+type ImportSource struct {
+	Pattern string // Pattern is a glob of the files, matched case-insensitively.
+	Format  string // Format is the name of the importer.
+}
+
+// ImportSources are the places searched by ScanImports. If they disagree,
+// the later sources win.
+var ImportSources = []ImportSource{
+	{"/etc/udev/rules.d/*trackpoint*", "udev"},
+	{"/etc/tmpfiles.d/*trackpoint*", "tmpfiles"},
+	{"/etc/rc.local", "script"},
+	{"/etc/systemd/system/*trackpoint*.service", "script"},
+	{"/usr/local/bin/*trackpoint*", "script"},
+}
+
+// ConfigSection is the part of the config file an import produces.
+type ConfigSection struct {
+	Values Values `yaml:"values,omitempty"` // Values are the known attributes.
+}
+
+// Conflict is an attribute that is assigned different values.
+type Conflict struct {
+	Key         string       // Key is the name of the attribute.
+	Assignments []Assignment // Assignments are all assignments of the attribute.
+}
+
+func (c Conflict) String() string {
+	values := make([]string, len(c.Assignments))
+	for i, a := range c.Assignments {
+		values[i] = fmt.Sprintf("%s (%s)", a.Value, a.Source)
+	}
+	return fmt.Sprintf("%s: %s", c.Key, strings.Join(values, ", "))
+}
+
+// Rejected is an assignment that is not imported.
+type Rejected struct {
+	Assignment
+	Err error // Err is the reason.
+}
+
+func (r Rejected) String() string {
+	return fmt.Sprintf("%s: %s: %v", r.Source, r.Key, r.Err)
+}
+
+// Merge builds the config section from the assignments, the later ones
+// winning, and reports the attributes that are assigned different values.
+// Assignments to unknown attributes, e.g. drvctl of the serio bus, and
+// invalid values are rejected and reported.
+func Merge(assignments []Assignment) (*ConfigSection, []Conflict, []Rejected) {
+	c := &ConfigSection{Values: make(Values)}
+	var keys []string
+	var rejected []Rejected
+	byKey := make(map[string][]Assignment)
+	for _, a := range assignments {
+		attr := LookupAttribute(a.Key)
+		if attr == nil {
+			rejected = append(rejected, Rejected{a, errors.New("not a known attribute")})
+			continue
+		}
+		if err := c.Values.Set(attr.Name, a.Value); err != nil {
+			rejected = append(rejected, Rejected{a, err})
+			continue
+		}
+		if _, ok := byKey[attr.Name]; !ok {
+			keys = append(keys, attr.Name)
+		}
+		byKey[attr.Name] = append(byKey[attr.Name], a)
+	}
+	var conflicts []Conflict
+	for _, key := range keys {
+		for _, a := range byKey[key][1:] {
+			if !sameValue(key, a.Value, byKey[key][0].Value) {
+				conflicts = append(conflicts, Conflict{Key: key, Assignments: byKey[key]})
+				break
+			}
+		}
+	}
+	return c, conflicts, rejected
+}
+
+// sameValue compares two values of an attribute, e.g. "1" and "true".
+// The values of extra attributes are compared as they are.
+func sameValue(key, a, b string) bool {
+	if attr := LookupAttribute(key); attr != nil {
+		x, errX := attr.Parse(a)
+		y, errY := attr.Parse(b)
+		return errX == nil && errY == nil && x == y
+	}
+	return a == b
+}
+
+// ScanImports imports the files of the ImportSources below root. It
+// returns the assignments and the files that were read.
+func ScanImports(root string) ([]Assignment, []string, error) {
+	return scanSources(root, ImportSources)
+}
+
+func scanSources(root string, sources []ImportSource) ([]Assignment, []string, error) {
+	var assignments []Assignment
+	var files []string
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		dir := filepath.Join(root, filepath.Dir(source.Pattern))
+		entries, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		pattern := strings.ToLower(filepath.Base(source.Pattern))
+		for _, e := range entries {
+			if ok, _ := filepath.Match(pattern, strings.ToLower(e.Name())); !ok || e.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			// e.g. /lib is a link to /usr/lib
+			if real, err := filepath.EvalSymlinks(path); err == nil {
+				if seen[real] {
+					continue
+				}
+				seen[real] = true
+			}
+			a, err := importFile(source.Format, path)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(a) > 0 {
+				assignments = append(assignments, a...)
+				files = append(files, path)
+			}
+		}
+	}
+	return assignments, files, nil
+}
+
+func importFile(format, path string) ([]Assignment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Importers[format](f, path)
+}
+
+// scanLines calls fn with each line of r and its number. Lines ending with
+// a backslash are joined with the next line.
+func scanLines(r io.Reader, fn func(line string, number int)) error {
+	scanner := bufio.NewScanner(r)
+	var line string
+	start, number := 0, 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			start = number
+		}
+		if strings.HasSuffix(text, "\\") {
+			line += strings.TrimSuffix(text, "\\") + " "
+			continue
+		}
+		line += text
+		if line != "" && !strings.HasPrefix(line, "#") {
+			fn(line, start)
+		}
+		line = ""
+	}
+	return scanner.Err()
+}
+
+var udevToken = regexp.MustCompile(`([A-Z_]+)(?:\{([^}]*)\})?\s*(==|!=|\+=|-=|:=|=)\s*"((?:[^"\\]|\\.)*)"`)
+
+// ImportUdev reads the attribute assignments of udev rules, both of the
+// form ATTR{device/key}="value" of ExportUdev and ATTR{key}="value" of
+// rules matching the serio device.
+func ImportUdev(r io.Reader, name string) ([]Assignment, error) {
+	var assignments []Assignment
+	err := scanLines(r, func(line string, number int) {
+		for _, m := range udevToken.FindAllStringSubmatch(line, -1) {
+			if m[1] != "ATTR" || (m[3] != "=" && m[3] != ":=") {
+				continue
+			}
+			key := strings.TrimPrefix(m[2], "device/")
+			if strings.Contains(key, "/") {
+				continue
+			}
+			value := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(m[4])
+			assignments = append(assignments, Assignment{key, value, fmt.Sprintf("%s:%d", name, number)})
+		}
+	})
+	return assignments, err
+}
+
+// ImportTmpfiles reads the w lines of a systemd-tmpfiles config that write
+// to the attributes of a TrackPoint.
+func ImportTmpfiles(r io.Reader, name string) ([]Assignment, error) {
+	var assignments []Assignment
+	err := scanLines(r, func(line string, number int) {
+		fields := strings.Fields(line)
+		if len(fields) < 7 || (fields[0] != "w" && fields[0] != "w+") {
+			return
+		}
+		if key, ok := sysfsKey(fields[1]); ok {
+			value := strings.Join(fields[6:], " ")
+			assignments = append(assignments, Assignment{key, value, fmt.Sprintf("%s:%d", name, number)})
+		}
+	})
+	return assignments, err
+}
+
+var (
+	// scriptWrite matches echo and printf redirected or piped to tee.
+	scriptWrite = regexp.MustCompile(`(?:echo(?:\s+-n)?|printf\s+['"]?%s(?:\\n)?['"]?)\s+(\S+)\s*(?:>>?|\|\s*(?:sudo\s+)?tee(?:\s+-a)?)\s*(\S+)`)
+	// scriptExport matches the write calls of ExportScript.
+	scriptExport = regexp.MustCompile(`^write\s+(\S+)\s+(\S+)$`)
+)
+
+// ImportScript reads shell scripts and systemd units that write values to
+// the attributes of a TrackPoint with echo or printf, and the scripts of
+// ExportScript.
+func ImportScript(r io.Reader, name string) ([]Assignment, error) {
+	var assignments []Assignment
+	err := scanLines(r, func(line string, number int) {
+		source := fmt.Sprintf("%s:%d", name, number)
+		if m := scriptExport.FindStringSubmatch(line); m != nil {
+			assignments = append(assignments, Assignment{shellUnquote(m[1]), shellUnquote(m[2]), source})
+			return
+		}
+		for _, m := range scriptWrite.FindAllStringSubmatch(line, -1) {
+			if key, ok := sysfsKey(shellUnquote(m[2])); ok {
+				assignments = append(assignments, Assignment{key, shellUnquote(m[1]), source})
+			}
+		}
+	})
+	return assignments, err
+}
+
+// sysfsKey returns the attribute a path writes to. Paths of known attributes
+// are accepted anywhere, others only below a serio device, so that Merge
+// reports them.
+func sysfsKey(path string) (string, bool) {
+	key := filepath.Base(path)
+	if LookupAttribute(key) != nil {
+		return key, true
+	}
+	return key, strings.HasPrefix(path, "/sys/") && strings.Contains(path, "/serio")
+}
+
+// shellUnquote removes the quotes and the separators around a shell word.
+func shellUnquote(s string) string {
+	s = strings.TrimRight(s, ";")
+	s = strings.Trim(s, `"`)
+	if strings.HasPrefix(s, "'") && strings.HasSuffix(s, "'") && len(s) > 1 {
+		s = strings.Replace(s[1:len(s)-1], `'\''`, "'", -1)
+	}
+	return strings.Trim(s, `'"`)
+}
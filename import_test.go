@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// checkImport imports a fixture and compares the YAML to another fixture.
+func checkImport(t *testing.T, format, fixture, expected string) {
+	assignments, err := importFile(format, fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, conflicts, rejected := Merge(assignments)
+	if len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts, got %v", conflicts)
+	}
+	// new_thing of the exports is no known attribute
+	for _, r := range rejected {
+		if r.Key != "new_thing" {
+			t.Fatalf("expected only new_thing to be rejected, got %v", r)
+		}
+	}
+	actual, err := yaml.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, actual)
+	}
+	// the result has to be a valid config
+	if err = yaml.UnmarshalStrict(actual, newSettings()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportExported(t *testing.T) {
+	expected, err := ioutil.ReadFile("testdata/udev/export.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImport(t, "udev", "testdata/udev/export.rules", string(expected))
+	checkImport(t, "tmpfiles", "testdata/tmpfiles/export.conf", string(expected))
+	checkImport(t, "script", "testdata/script/export.sh", string(expected))
+	checkImport(t, "udev", "testdata/udev/serio.rules", "values:\n  sensitivity: 180\n  speed: 120\n")
+}
+
+func TestImportUdev(t *testing.T) {
+	a, err := ImportUdev(strings.NewReader(`ACTION=="add", ATTR{name}=="TPPS/2 IBM TrackPoint"`), "test")
+	if err != nil || len(a) != 0 {
+		t.Fatalf("expected no assignments, got %v, %v", a, err)
+	}
+	a, err = ImportUdev(strings.NewReader(`ACTION=="add", ATTR{device/sensitivity}="300", ATTR{device/speed}="120"`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the invalid value does not stop the import of the others
+	c, _, rejected := Merge(a)
+	if len(rejected) != 1 || !strings.HasPrefix(rejected[0].String(), "test:1: sensitivity: ") {
+		t.Fatalf("expected sensitivity to be rejected at test:1, got %v", rejected)
+	}
+	if len(c.Values) != 1 || c.Values["speed"] != 120 {
+		t.Fatalf("expected %v, got %v", Values{"speed": 120}, c.Values)
+	}
+}
+
+func TestImportScript_Unknown(t *testing.T) {
+	script := "echo 200 > /sys/devices/platform/i8042/serio1/serio2/sensitivity\n" +
+		"echo rescan > /sys/devices/platform/i8042/serio1/drvctl\n"
+	a, err := ImportScript(strings.NewReader(script), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _, rejected := Merge(a)
+	if len(rejected) != 1 || rejected[0].String() != "test:2: drvctl: not a known attribute" {
+		t.Fatalf("expected drvctl to be rejected, got %v", rejected)
+	}
+	if len(c.Values) != 1 || c.Values["sensitivity"] != 200 {
+		t.Fatalf("expected %v, got %v", Values{"sensitivity": 200}, c.Values)
+	}
+}
+
+func TestScanImports(t *testing.T) {
+	// 70-mouse.rules is not about the TrackPoint and is skipped
+	root := "testdata/import"
+	assignments, files, err := ScanImports(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFiles := []string{
+		"etc/udev/rules.d/90-TrackPoint.rules",
+		"etc/tmpfiles.d/trackpoint.conf",
+		"etc/rc.local",
+		"etc/systemd/system/trackpoint.service",
+	}
+	if len(files) != len(expectedFiles) {
+		t.Fatalf("expected %v, got %v", expectedFiles, files)
+	}
+	for i, f := range expectedFiles {
+		if files[i] != filepath.Join(root, f) {
+			t.Fatalf("expected %v, got %v", filepath.Join(root, f), files[i])
+		}
+	}
+
+	c, conflicts, rejected := Merge(assignments)
+	if len(rejected) != 0 {
+		t.Fatalf("expected nothing to be rejected, got %v", rejected)
+	}
+	expected := Values{"sensitivity": 180, "speed": 120, "inertia": 6, "press_to_select": 1, "drift_time": 9}
+	if len(c.Values) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, c.Values)
+	}
+	for key, value := range expected {
+		if c.Values[key] != value {
+			t.Fatalf("expected %v for %v, got %v", value, key, c.Values[key])
+		}
+	}
+	// press_to_select is "1" and "true", which is no conflict
+	if len(conflicts) != 1 || conflicts[0].Key != "sensitivity" {
+		t.Fatalf("expected a conflict for sensitivity, got %v", conflicts)
+	}
+	expectedConflict := "sensitivity: 200 (testdata/import/etc/udev/rules.d/90-TrackPoint.rules:1), " +
+		"180 (testdata/import/etc/tmpfiles.d/trackpoint.conf:2)"
+	if conflicts[0].String() != expectedConflict {
+		t.Fatalf("expected %v, got %v", expectedConflict, conflicts[0])
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InputDir is the directory of the input devices.
+var InputDir = "/sys/class/input"
+
+var (
+	// InputPollInterval is the interval at which the input devices are polled.
+	InputPollInterval = 5 * time.Second
+	// InputSettleTime is how long the input devices have to be quiet before
+	// they are scanned, so that flapping docks do not cause write storms.
+	InputSettleTime = 2 * time.Second
+)
+
+// ExternalPointingDevices returns the pointing devices other than the
+// TrackPoint and the devices built into the machine. Devices on the
+// passthrough port of the TrackPoint count as external.
+func ExternalPointingDevices(trackpoint *Device) ([]*Device, error) {
+	inputs, err := filepath.Glob(filepath.Join(InputDir, "input*"))
+	if err != nil {
+		return nil, err
+	}
+	var passthrough string
+	if trackpoint != nil && trackpoint.Phys != "" {
+		passthrough = path.Dir(trackpoint.Phys) + "/"
+	}
+	var devices []*Device
+	for _, input := range inputs {
+		d := newDevice(input, input)
+		switch {
+		case !isPointingDevice(input), d.Phys == "", strings.Contains(d.Name, TrackPointName):
+			// not a mouse, virtual or the TrackPoint itself
+		case strings.HasPrefix(d.Phys, "isa0060/") &&
+			(passthrough == "" || !strings.HasPrefix(d.Phys, passthrough)):
+			// built into the machine, e.g. the touchpad
+		default:
+			devices = append(devices, d)
+		}
+	}
+	return devices, nil
+}
+
+// isPointingDevice checks if the input device reports relative X and Y movement.
+func isPointingDevice(input string) bool {
+	words := strings.Fields(readTrimmed(filepath.Join(input, "capabilities", "rel")))
+	if len(words) == 0 {
+		return false
+	}
+	// the lowest bits are in the last word, REL_X is bit 0 and REL_Y bit 1
+	bits, err := strconv.ParseUint(words[len(words)-1], 16, 64)
+	return err == nil && bits&3 == 3
+}
+
+// WatchPointingDevices sends if external pointing devices are present,
+// initially and whenever it changes. It checks on input uevents and every
+// InputPollInterval, after the devices were quiet for InputSettleTime.
+func WatchPointingDevices(hotplug *Hotplug, trackpoint func() *Device, stop <-chan bool) <-chan bool {
+	c := make(chan bool, 1)
+	events := hotplug.Subscribe("input")
+	trigger := make(chan bool)
+	settled := DebounceBool(InputSettleTime, trigger)
+	present := func() (bool, bool) {
+		devices, err := ExternalPointingDevices(trackpoint())
+		return len(devices) > 0, err == nil
+	}
+	go func() {
+		defer close(trigger)
+		ticker := time.NewTicker(InputPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-events:
+			case <-ticker.C:
+			}
+			select {
+			case <-stop:
+				return
+			case trigger <- true:
+			}
+		}
+	}()
+	go func() {
+		last, ok := present()
+		if ok {
+			c <- last
+		}
+		for range settled {
+			p, ok := present()
+			if !ok || p == last {
+				continue
+			}
+			last = p
+			select {
+			case c <- p:
+			case <-stop:
+			}
+		}
+	}()
+	return c
+}
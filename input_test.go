@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeInputs creates an input class directory with the given devices, each
+// a name, phys and rel capabilities, and points InputDir to it.
+func fakeInputs(t *testing.T, devices map[string][3]string) (cleanup func()) {
+	dir, err := ioutil.TempDir("", "trackpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := InputDir
+	InputDir = dir
+	for input, d := range devices {
+		addInput(t, input, d)
+	}
+	return func() {
+		InputDir = old
+		os.RemoveAll(dir)
+	}
+}
+
+func addInput(t *testing.T, input string, d [3]string) {
+	files := map[string]string{"name": d[0], "phys": d[1], "capabilities/rel": d[2]}
+	for name, content := range files {
+		path := filepath.Join(InputDir, input, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+var trackpoint = &Device{Name: "TPPS/2 IBM TrackPoint", Phys: "isa0060/serio1/serio2/input0"}
+
+func TestExternalPointingDevices(t *testing.T) {
+	defer fakeInputs(t, map[string][3]string{
+		"input1": {"AT Translated Set 2 keyboard", "isa0060/serio0/input0", "0"},
+		"input5": {"SynPS/2 Synaptics TouchPad", "isa0060/serio1/input0", "3"},
+		"input7": {"TPPS/2 IBM TrackPoint", "isa0060/serio1/serio2/input0", "3"},
+		"input9": {"ydotoold virtual device", "", "1c3"},
+	})()
+
+	devices, err := ExternalPointingDevices(trackpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 0 {
+		t.Fatalf("expected no devices, got %v", devices[0])
+	}
+
+	addInput(t, "input20", [3]string{"Logitech USB Optical Mouse", "usb-0000:00:14.0-1/input0", "1 0 143"})
+	addInput(t, "input21", [3]string{"PS/2 Generic Mouse", "isa0060/serio1/serio2/serio3/input0", "3"})
+	devices, err = ExternalPointingDevices(trackpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("expected %v devices, got %v", 2, len(devices))
+	}
+	if devices[1].Name != "PS/2 Generic Mouse" {
+		t.Fatalf("expected %v, got %v", "PS/2 Generic Mouse", devices[1].Name)
+	}
+}
+
+func TestWatchPointingDevices(t *testing.T) {
+	defer fakeInputs(t, map[string][3]string{
+		"input7": {"TPPS/2 IBM TrackPoint", "isa0060/serio1/serio2/input0", "3"},
+	})()
+	old := InputSettleTime
+	InputSettleTime = 50 * time.Millisecond
+	defer func() { InputSettleTime = old }()
+
+	hotplug := NewHotplug()
+	stop := make(chan bool)
+	defer close(stop)
+	mouse := WatchPointingDevices(hotplug, func() *Device { return trackpoint }, stop)
+
+	expect := func(expected bool) {
+		select {
+		case m := <-mouse:
+			if m != expected {
+				t.Fatalf("expected %v, got %v", expected, m)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %v, got nothing", expected)
+		}
+	}
+	expect(false)
+
+	// a flapping device is only reported once it settled
+	mousePath := filepath.Join(InputDir, "input20")
+	for i := 0; i < 3; i++ {
+		addInput(t, "input20", [3]string{"Logitech USB Optical Mouse", "usb-0000:00:14.0-1/input0", "143"})
+		hotplug.Publish(&Uevent{Action: "add", Subsystem: "input"})
+		os.RemoveAll(mousePath)
+		hotplug.Publish(&Uevent{Action: "remove", Subsystem: "input"})
+	}
+	addInput(t, "input20", [3]string{"Logitech USB Optical Mouse", "usb-0000:00:14.0-1/input0", "143"})
+	hotplug.Publish(&Uevent{Action: "add", Subsystem: "input"})
+	expect(true)
+	select {
+	case m := <-mouse:
+		t.Fatalf("expected nothing, got %v", m)
+	case <-time.After(4 * InputSettleTime):
+	}
+
+	os.RemoveAll(mousePath)
+	hotplug.Publish(&Uevent{Action: "remove", Subsystem: "input"})
+	expect(false)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const (
+	// ConfigPath is the path of the installed config file.
+	ConfigPath = "/etc/trackpoint.yml"
+	// UnitPath is the path of the installed systemd unit.
+	UnitPath = "/etc/systemd/system/trackpoint.service"
+	// RulesPath is the path of the installed udev rules.
+	RulesPath = "/etc/udev/rules.d/90-trackpoint.rules"
+	// ManifestName is the name of the file in the state directory that
+	// records the installed files.
+	ManifestName = "installed.json"
+)
+
+// DefaultConfig is the commented config shipped with the tool, which is
+// installed if there is none.
+//
+//go:embed trackpoint.yml
+var DefaultConfig []byte
+
+// Artifact is a file written by the installer.
+type Artifact struct {
+	Path    string      // Path is the absolute path of the file below the root.
+	Mode    os.FileMode // Mode are the permissions of the file.
+	Content []byte      // Content is the content of the file.
+	Config  bool        // Config files are never replaced once they exist.
+}
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=Configure the TrackPoint
+Documentation=https://github.com/autermann/trackpoint
+
+[Service]
+Type=simple
+ExecStart={{.Binary}} --daemon --config {{.Config}}
+Restart=on-failure
+# stop only the daemon, which restores the values through its helper
+KillMode=mixed
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+var rulesTemplate = template.Must(template.New("rules").Parse(`# Starts trackpoint when a TrackPoint appears.
+ACTION=="add", SUBSYSTEM=="input", KERNEL=="input*", ATTR{name}=="*{{.Name}}*", TAG+="systemd", ENV{SYSTEMD_WANTS}+="trackpoint.service"
+`))
+
+// Artifacts returns the files that install the daemon with the binary.
+func Artifacts(binary string) ([]Artifact, error) {
+	data := struct{ Binary, Config, Name string }{shellQuote(binary), ConfigPath, TrackPointName}
+	var unit, rules bytes.Buffer
+	if err := unitTemplate.Execute(&unit, data); err != nil {
+		return nil, err
+	}
+	if err := rulesTemplate.Execute(&rules, data); err != nil {
+		return nil, err
+	}
+	return []Artifact{
+		{Path: ConfigPath, Mode: 0644, Content: DefaultConfig, Config: true},
+		{Path: UnitPath, Mode: 0644, Content: unit.Bytes()},
+		{Path: RulesPath, Mode: 0644, Content: rules.Bytes()},
+	}, nil
+}
+
+// Manifest maps the installed files to the hashes of their content.
+type Manifest map[string]string
+
+func manifestPath(root string) string {
+	return filepath.Join(root, DefaultStateDir, ManifestName)
+}
+
+// LoadManifest reads the manifest below root, which is empty if nothing was installed.
+func LoadManifest(root string) (Manifest, error) {
+	m := make(Manifest)
+	data, err := ioutil.ReadFile(manifestPath(root))
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return m, json.Unmarshal(data, &m)
+}
+
+// Save writes the manifest below root, or removes it if it is empty.
+func (m Manifest) Save(root string) error {
+	path := manifestPath(root)
+	if len(m) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Install writes the artifacts below root. It refuses to replace files that
+// were not installed by it or were changed since, unless force is set, and
+// keeps config files that exist. It returns the written files.
+func Install(root string, artifacts []Artifact, force bool) ([]string, error) {
+	m, err := LoadManifest(root)
+	if err != nil {
+		return nil, err
+	}
+	var write []Artifact
+	var modified []string
+	for _, a := range artifacts {
+		path := filepath.Join(root, a.Path)
+		current, err := ioutil.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+			write = append(write, a)
+		case err != nil:
+			return nil, err
+		case bytes.Equal(current, a.Content):
+			m[a.Path] = hashOf(current)
+		case a.Config:
+			log.Printf("%s: keeping the existing config", path)
+		case force || m[a.Path] == hashOf(current):
+			write = append(write, a)
+		default:
+			modified = append(modified, path)
+		}
+	}
+	if len(modified) > 0 {
+		return nil, fmt.Errorf("refusing to replace files that were changed locally: %s", strings.Join(modified, ", "))
+	}
+	var written []string
+	for _, a := range write {
+		path := filepath.Join(root, a.Path)
+		if err := writeFileAtomic(path, a.Content); err != nil {
+			return written, err
+		}
+		if err := os.Chmod(path, a.Mode); err != nil {
+			return written, err
+		}
+		m[a.Path] = hashOf(a.Content)
+		written = append(written, path)
+	}
+	return written, m.Save(root)
+}
+
+// Uninstall removes the installed files below root that were not changed
+// since. It returns the removed files and the files it kept.
+func Uninstall(root string) (removed, kept []string, err error) {
+	m, err := LoadManifest(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	paths := make([]string, 0, len(m))
+	for p := range m {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		path := filepath.Join(root, p)
+		current, err := ioutil.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return removed, kept, err
+		case hashOf(current) != m[p]:
+			kept = append(kept, path)
+		default:
+			if err = os.Remove(path); err != nil {
+				return removed, kept, err
+			}
+			removed = append(removed, path)
+		}
+		delete(m, p)
+	}
+	return removed, kept, m.Save(root)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultConfig(t *testing.T) {
+	path, cleanup := writeConfig(t, string(DefaultConfig))
+	defer cleanup()
+	s := newSettings()
+	if err := s.ReadYAML(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range Attributes {
+		if actual := s.Values[a.Name]; actual != a.Default {
+			t.Fatalf("expected %v for %v, got %v", a.Default, a.Name, actual)
+		}
+	}
+}
+
+func TestInstall(t *testing.T) {
+	root, err := ioutil.TempDir("", "install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	artifacts, err := Artifacts("/usr/local/bin/trackpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := Install(root, artifacts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != len(artifacts) {
+		t.Fatalf("expected %v written files, got %v", len(artifacts), written)
+	}
+	unit, err := ioutil.ReadFile(filepath.Join(root, UnitPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(unit, []byte("ExecStart=/usr/local/bin/trackpoint --daemon --config "+ConfigPath)) {
+		t.Fatalf("expected the binary in the unit, got\n%s", unit)
+	}
+
+	// a new binary replaces the unchanged unit and keeps the changed config
+	config := filepath.Join(root, ConfigPath)
+	if err = ioutil.WriteFile(config, []byte("interval: 1m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if artifacts, err = Artifacts("/usr/bin/trackpoint"); err != nil {
+		t.Fatal(err)
+	}
+	if written, err = Install(root, artifacts, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || written[0] != filepath.Join(root, UnitPath) {
+		t.Fatalf("expected the unit to be written, got %v", written)
+	}
+
+	// a changed unit is not replaced without force
+	rules := filepath.Join(root, RulesPath)
+	if err = ioutil.WriteFile(rules, []byte("# mine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Install(root, artifacts, false); err == nil || !strings.Contains(err.Error(), rules) {
+		t.Fatalf("expected error for %v, got %v", rules, err)
+	}
+
+	removed, kept, err := Uninstall(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || len(kept) != 2 {
+		t.Fatalf("expected 1 removed and 2 kept files, got %v and %v", removed, kept)
+	}
+	if _, err = os.Stat(manifestPath(root)); !os.IsNotExist(err) {
+		t.Fatalf("expected the manifest to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// KernelReleasePath is the path of the release of the running kernel.
+var KernelReleasePath = "/proc/sys/kernel/osrelease"
+
+// KernelRelease returns the release of the running kernel, e.g. "5.15.0-91-generic".
+func KernelRelease() (string, error) {
+	bytes, err := ioutil.ReadFile(KernelReleasePath)
+	return strings.TrimSpace(string(bytes)), err
+}
+
+// CompareVersions compares the numeric parts of two kernel versions and
+// returns -1, 0 or 1. Suffixes like "-generic" are ignored.
+func CompareVersions(a, b string) int {
+	x, y := versionParts(a), versionParts(b)
+	for i := 0; i < len(x) || i < len(y); i++ {
+		var p, q int
+		if i < len(x) {
+			p = x[i]
+		}
+		if i < len(y) {
+			q = y[i]
+		}
+		switch {
+		case p < q:
+			return -1
+		case p > q:
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	if i := strings.IndexAny(v, "-+_ "); i >= 0 {
+		v = v[:i]
+	}
+	var parts []int
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
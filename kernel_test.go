@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"5.15.0-91-generic", "5.15", 0},
+		{"4.4.0", "4.17", -1},
+		{"6.1.12-arch1-1", "4.17", 1},
+		{"3.19", "3.19.1", -1},
+		{"2.6.32", "2.6.14", 1},
+	}
+	for _, test := range tests {
+		if actual := CompareVersions(test.a, test.b); actual != test.expected {
+			t.Fatalf("expected %v for %v <=> %v, got %v", test.expected, test.a, test.b, actual)
+		}
+	}
+}
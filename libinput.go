@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// LibinputQuirksPath is the file of the local libinput quirks.
+	LibinputQuirksPath = "/etc/libinput/local-overrides.quirks"
+	// HwdbPath is the hwdb file written for the TrackPoint.
+	HwdbPath = "/etc/udev/hwdb.d/71-trackpoint.hwdb"
+)
+
+// The markers of the block of the local libinput quirks that belongs to the tool.
+const (
+	quirksBegin = "# BEGIN trackpoint"
+	quirksEnd   = "# END trackpoint"
+)
+
+// Libinput are the settings of libinput and the hwdb for the TrackPoint,
+// which complement the values of the device. udev writes the sensitivity
+// of the hwdb to the device, so it has to be the same as the value.
+type Libinput struct {
+	Multiplier  float64 `yaml:"multiplier"`  // Multiplier is the AttrTrackpointMultiplier quirk, 0 if not set.
+	Sensitivity uint8   `yaml:"sensitivity"` // Sensitivity is the POINTINGSTICK_SENSITIVITY hwdb property, 0 if not set.
+}
+
+// Validate checks the libinput settings.
+func (l *Libinput) Validate() error {
+	if l.Multiplier < 0 || l.Multiplier > 8 {
+		return fmt.Errorf("multiplier must be between 0 and 8, got %v", l.Multiplier)
+	}
+	return nil
+}
+
+// libinputMatch returns the globs of the device name and of the DMI
+// modalias of the machine. The kernel removes spaces from the modalias.
+func libinputMatch(s *Settings) (name, dmi string) {
+	name = "*" + TrackPointName + "*"
+	if s.Device != nil && s.Device.Name != "" {
+		name = s.Device.Name
+	}
+	if s.Env == nil || s.Env.DMI.Vendor == "" {
+		return name, ""
+	}
+	r := strings.NewReplacer(" ", "", ":", "")
+	dmi = "dmi:*svn" + r.Replace(s.Env.DMI.Vendor) + ":*"
+	if s.Env.DMI.Product != "" {
+		dmi += "pn" + r.Replace(s.Env.DMI.Product) + ":*"
+	}
+	return name, dmi
+}
+
+// ExportQuirks writes a libinput quirks section for the TrackPoint of this machine.
+func ExportQuirks(s *Settings, w io.Writer) error {
+	if s.Libinput == nil || s.Libinput.Multiplier == 0 {
+		return fmt.Errorf("the libinput section has no multiplier")
+	}
+	name, dmi := libinputMatch(s)
+	lines := []string{exportHeader(s, "#"), "[TrackPoint]", "MatchName=" + name}
+	if dmi != "" {
+		lines = append(lines, "MatchDMIModalias="+dmi)
+	}
+	lines = append(lines, fmt.Sprintf("AttrTrackpointMultiplier=%v", s.Libinput.Multiplier))
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// ExportHwdb writes a hwdb entry for the TrackPoint of this machine.
+func ExportHwdb(s *Settings, w io.Writer) error {
+	if s.Libinput == nil || s.Libinput.Sensitivity == 0 {
+		return fmt.Errorf("the libinput section has no sensitivity")
+	}
+	name, dmi := libinputMatch(s)
+	if dmi == "" {
+		dmi = "*"
+	}
+	_, err := fmt.Fprintf(w, "%s\nevdev:name:%s:%s\n POINTINGSTICK_SENSITIVITY=%d\n",
+		exportHeader(s, "#"), name, dmi, s.Libinput.Sensitivity)
+	return err
+}
+
+// InstallLibinput writes the libinput quirks and the hwdb file below root
+// and returns the paths of the files. The block of the tool in the local
+// quirks is replaced, the rest of the file is kept.
+func InstallLibinput(s *Settings, root string) ([]string, error) {
+	if s.Libinput == nil {
+		return nil, fmt.Errorf("the config has no libinput section")
+	}
+	var installed []string
+	if s.Libinput.Multiplier != 0 {
+		var b bytes.Buffer
+		if err := ExportQuirks(s, &b); err != nil {
+			return nil, err
+		}
+		path := filepath.Join(root, LibinputQuirksPath)
+		if err := replaceBlock(path, b.String()); err != nil {
+			return nil, err
+		}
+		installed = append(installed, path)
+	}
+	if s.Libinput.Sensitivity != 0 {
+		var b bytes.Buffer
+		if err := ExportHwdb(s, &b); err != nil {
+			return nil, err
+		}
+		path := filepath.Join(root, HwdbPath)
+		if err := writeFileAtomic(path, b.Bytes()); err != nil {
+			return nil, err
+		}
+		installed = append(installed, path)
+		if root == "/" {
+			updateHwdb()
+		}
+	}
+	return installed, nil
+}
+
+// replaceBlock replaces the block of the tool in the file with content, or
+// appends it if there is none.
+func replaceBlock(path, content string) error {
+	old, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	block := quirksBegin + "\n" + content + quirksEnd + "\n"
+	text := string(old)
+	begin, end := strings.Index(text, quirksBegin), strings.Index(text, quirksEnd)
+	if begin >= 0 && end > begin {
+		text = text[:begin] + block + strings.TrimPrefix(text[end+len(quirksEnd):], "\n")
+	} else {
+		if text != "" && !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		text += block
+	}
+	return writeFileAtomic(path, []byte(text))
+}
+
+// writeFileAtomic writes the file through a temporary file, so it is never
+// seen half written.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// updateHwdb rebuilds the hwdb and lets udev apply it to the input devices.
+func updateHwdb() {
+	execLogged([]string{"systemd-hwdb", "update"}, []string{"udevadm", "trigger", "--subsystem-match=input"})
+}
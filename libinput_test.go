@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// libinputSettings are the settings exported to the fixtures in testdata/libinput.
+func libinputSettings() *Settings {
+	s := exportSettings()
+	s.Env = &Environment{DMI: DMI{Vendor: "LENOVO", Product: "20S0CTO1WW", Version: "ThinkPad T14 Gen 1"}}
+	s.Libinput = &Libinput{Multiplier: 1.25, Sensitivity: 200}
+	return s
+}
+
+func TestExportQuirks(t *testing.T) {
+	checkExport(t, libinputSettings(), "quirks", "testdata/libinput/export.quirks")
+}
+
+func TestExportHwdb(t *testing.T) {
+	checkExport(t, libinputSettings(), "hwdb", "testdata/libinput/export.hwdb")
+}
+
+func TestLibinputSensitivity(t *testing.T) {
+	s := libinputSettings()
+	if err := s.validateConfig(); err != nil {
+		t.Fatal(err)
+	}
+	s.Values["sensitivity"] = 180
+	if err := s.validateConfig(); err == nil || !strings.Contains(err.Error(), "values.sensitivity") {
+		t.Fatalf("expected an error about values.sensitivity, got %v", err)
+	}
+	delete(s.Values, "sensitivity")
+	if err := s.validateConfig(); err == nil {
+		t.Fatal("expected an error without values.sensitivity")
+	}
+}
+
+func TestLibinputMatch(t *testing.T) {
+	s := newSettings()
+	name, dmi := libinputMatch(s)
+	if name != "*TrackPoint*" || dmi != "" {
+		t.Fatalf("expected *TrackPoint* without DMI, got %v and %v", name, dmi)
+	}
+	s.Env = &Environment{DMI: DMI{Vendor: "Some Vendor"}}
+	if _, dmi = libinputMatch(s); dmi != "dmi:*svnSomeVendor:*" {
+		t.Fatalf("expected dmi:*svnSomeVendor:*, got %v", dmi)
+	}
+}
+
+func TestInstallLibinput(t *testing.T) {
+	root, err := ioutil.TempDir("", "libinput")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	quirks := filepath.Join(root, LibinputQuirksPath)
+	other := "[Other]\nMatchName=Other\nAttrPalmSizeThreshold=5\n"
+	if err = os.MkdirAll(filepath.Dir(quirks), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(quirks, []byte(other), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := libinputSettings()
+	for i := 0; i < 2; i++ {
+		installed, err := InstallLibinput(s, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(installed) != 2 {
+			t.Fatalf("expected 2 installed files, got %v", installed)
+		}
+		s.Libinput.Multiplier = 2
+	}
+
+	data, err := ioutil.ReadFile(quirks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+	if !strings.HasPrefix(text, other) {
+		t.Fatalf("expected the other quirks to be kept, got\n%s", text)
+	}
+	if strings.Count(text, quirksBegin) != 1 || !strings.Contains(text, "AttrTrackpointMultiplier=2\n") {
+		t.Fatalf("expected a single replaced block, got\n%s", text)
+	}
+	if _, err = os.Stat(filepath.Join(root, HwdbPath)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = InstallLibinput(newSettings(), root); err == nil {
+		t.Fatal("expected error without a libinput section")
+	}
+}
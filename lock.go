@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// PidFileName is the name of the pidfile of the daemon in the run directory.
+const PidFileName = "trackpoint.pid"
+
+// LockedError indicates that another instance holds a lock.
+type LockedError struct {
+	Path string // Path is the lock file.
+	Pid  int    // Pid is the process holding the lock, 0 if unknown.
+}
+
+func (e *LockedError) Error() string {
+	if e.Pid == 0 {
+		return fmt.Sprintf("%s is locked by another instance", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by another instance (pid %d)", e.Path, e.Pid)
+}
+
+// Lock is an exclusive flock on a file holding the pid of its owner.
+type Lock struct {
+	Path string // Path is the lock file.
+	file *os.File
+}
+
+// LockDevice locks the device against other instances. If it is locked, it
+// waits for the lock or fails with *LockedError.
+func LockDevice(runDir, device string, wait bool) (*Lock, error) {
+	name := strings.Replace(strings.Trim(filepath.Clean(device), "/"), "/", "-", -1) + ".lock"
+	return acquireLock(filepath.Join(runDir, name), wait)
+}
+
+// LockPidFile creates the pidfile of the daemon and locks it, or fails with
+// *LockedError if another daemon runs.
+func LockPidFile(runDir string) (*Lock, error) {
+	return acquireLock(filepath.Join(runDir, PidFileName), false)
+}
+
+func acquireLock(path string, wait bool) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		pid, _ := ReadPid(path)
+		if !wait {
+			f.Close()
+			return nil, &LockedError{Path: path, Pid: pid}
+		}
+		log.Printf("waiting for %s held by pid %d", path, pid)
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Lock{Path: path, file: f}, nil
+}
+
+// Release clears the pid and releases the lock. The file is kept, as another
+// instance may already wait for the lock on it.
+func (l *Lock) Release() error {
+	if err := l.file.Truncate(0); err != nil {
+		log.Print(err)
+	}
+	return l.file.Close()
+}
+
+// ProbeLock checks if an instance holds the lock at path without creating,
+// writing or removing the file. It returns the pid of the holder, 0 if it
+// is unknown.
+func ProbeLock(path string) (pid int, locked bool, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	// closing the file releases the shared lock
+	defer f.Close()
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		pid, _ = ReadPid(path)
+		return pid, true, nil
+	}
+	return 0, false, err
+}
+
+// ReadPid reads the pid from a pidfile or lock file.
+func ReadPid(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// applyOnce writes the settings under the lock of the device and records
+// the changes.
+func applyOnce(settings *Settings, source string, wait bool) error {
+	lock, err := LockDevice(settings.RunDir, settings.SysfsPath, wait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	rw := NewSettingsReaderWriter(settings.SysfsPath)
+	keys := settings.DeviceKeys()
+	before, _ := rw.Snapshot(keys)
+	err = rw.Set(settings)
+	NewHistory(settings.StateDir).Record(source, rw, keys, before)
+	return err
+}
+
+// lockDevice locks the device of the daemon for its lifetime, replacing the
+// lock of a previous device. Other instances are waited for, as they only
+// lock the device while they write to it. The caller holds the lock.
+func (d *SettingsDaemon) lockDevice() error {
+	if d.deviceLock != nil {
+		d.deviceLock.Release()
+		d.deviceLock = nil
+	}
+	lock, err := LockDevice(d.Settings.RunDir, d.Settings.SysfsPath, true)
+	if err != nil {
+		return err
+	}
+	d.deviceLock = lock
+	return nil
+}
+
+// AcquireLocks creates the pidfile and locks the device for the lifetime of
+// the daemon. It fails if another daemon runs.
+func (d *SettingsDaemon) AcquireLocks() error {
+	d.Lock()
+	defer d.Unlock()
+	pidFile, err := LockPidFile(d.Settings.RunDir)
+	if err != nil {
+		return err
+	}
+	if err = d.lockDevice(); err != nil {
+		pidFile.Release()
+		return err
+	}
+	d.pidFile = pidFile
+	return nil
+}
+
+// ReleaseLocks releases the locks of AcquireLocks.
+func (d *SettingsDaemon) ReleaseLocks() {
+	d.Lock()
+	defer d.Unlock()
+	for _, l := range []*Lock{d.deviceLock, d.pidFile} {
+		if l != nil {
+			l.Release()
+		}
+	}
+	d.deviceLock, d.pidFile = nil, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	device := "/sys/devices/platform/i8042/serio1/serio2"
+
+	lock, err := LockDevice(dir, device, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dir, "sys-devices-platform-i8042-serio1-serio2.lock"); lock.Path != expected {
+		t.Fatalf("expected %v, got %v", expected, lock.Path)
+	}
+	if pid, err := ReadPid(lock.Path); err != nil || pid != os.Getpid() {
+		t.Fatalf("expected pid %v, got %v (%v)", os.Getpid(), pid, err)
+	}
+
+	// flocks of different open files exclude each other within a process too
+	_, err = LockDevice(dir, device, false)
+	if e, ok := err.(*LockedError); !ok || e.Pid != os.Getpid() {
+		t.Fatalf("expected *LockedError with pid %v, got %v", os.Getpid(), err)
+	}
+	other, err := LockDevice(dir, "/sys/devices/other", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Release()
+
+	acquired := make(chan *Lock)
+	go func() {
+		l, err := LockDevice(dir, device, true)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- l
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected to wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	lock.Release()
+	select {
+	case l := <-acquired:
+		l.Release()
+	case <-time.After(time.Second):
+		t.Fatal("expected the lock after the release")
+	}
+}
+
+func TestLockPidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lock, err := LockPidFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LockPidFile(dir); err == nil {
+		t.Fatal("expected error for a second daemon")
+	}
+	if err = lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	// the pidfile is kept without a pid
+	if data, err := ioutil.ReadFile(filepath.Join(dir, PidFileName)); err != nil || len(data) != 0 {
+		t.Fatalf("expected an empty pidfile, got %q, %v", data, err)
+	}
+}
+
+func TestProbeLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, PidFileName)
+
+	if _, locked, err := ProbeLock(path); err != nil || locked {
+		t.Fatalf("expected no lock, got %v, %v", locked, err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the probe not to create %v, got %v", path, err)
+	}
+	lock, err := LockPidFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid, locked, err := ProbeLock(path); err != nil || !locked || pid != os.Getpid() {
+		t.Fatalf("expected the lock of pid %v, got %v, %v, %v", os.Getpid(), pid, locked, err)
+	}
+	lock.Release()
+	if _, locked, err := ProbeLock(path); err != nil || locked {
+		t.Fatalf("expected no lock, got %v, %v", locked, err)
+	}
+	// the probe leaves the pidfile to the next daemon
+	if lock, err = LockPidFile(dir); err != nil {
+		t.Fatal(err)
+	}
+	lock.Release()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Environment describes the machine the settings are resolved for.
+type Environment struct {
+	Hostname string  // Hostname is the host name of the machine.
+	DMI      DMI     // DMI is the DMI identification of the machine.
+	Device   *Device // Device is the TrackPoint.
+	Kernel   string  // Kernel is the release of the running kernel.
+}
+
+// DetectEnvironment detects the environment of the device.
+func DetectEnvironment(d *Device) *Environment {
+	env := &Environment{DMI: ReadDMI(), Device: d}
+	env.Hostname, _ = os.Hostname()
+	env.Kernel, _ = KernelRelease()
+	return env
+}
+
+// Conditions are the conditions of a match block. Empty conditions match
+// everything. All conditions except Kernel and Variant are globs.
+type Conditions struct {
+	Hostname string  `yaml:"hostname"` // Hostname is matched against the host name.
+	Vendor   string  `yaml:"vendor"`   // Vendor is matched against the DMI system vendor.
+	Product  string  `yaml:"product"`  // Product is matched against the DMI product name.
+	Version  string  `yaml:"version"`  // Version is matched against the DMI product version.
+	Variant  Variant `yaml:"variant"`  // Variant is the TrackPoint variant.
+	Device   string  `yaml:"device"`   // Device is matched against the name of the device.
+	Phys     string  `yaml:"phys"`     // Phys is matched against the physical path of the device.
+	Kernel   string  `yaml:"kernel"`   // Kernel is a glob or a comparison like ">=5.4".
+}
+
+// Overlay is a profile and values that are applied on top of other values.
+type Overlay struct {
+	Profile string `yaml:"profile"` // Profile is the name of the profile to apply.
+	Values  Values `yaml:"values"`  // Values are applied after the profile.
+}
+
+// MatchBlock is a block of the config file that applies an overlay if its
+// conditions match.
+type MatchBlock struct {
+	Conditions `yaml:",inline"`
+	Overlay    `yaml:",inline"`
+}
+
+// Matches checks if the conditions match the environment.
+func (c *Conditions) Matches(env *Environment) bool {
+	var name, phys string
+	variant := VariantUnknown
+	if env.Device != nil {
+		name, phys, variant = env.Device.Name, env.Device.Phys, env.Device.Variant
+	}
+	return glob(c.Hostname, env.Hostname) &&
+		glob(c.Vendor, env.DMI.Vendor) &&
+		glob(c.Product, env.DMI.Product) &&
+		glob(c.Version, env.DMI.Version) &&
+		(c.Variant == "" || c.Variant == variant) &&
+		glob(c.Device, name) &&
+		glob(c.Phys, phys) &&
+		matchKernel(c.Kernel, env.Kernel)
+}
+
+// Validate checks the syntax of the conditions.
+func (c *Conditions) Validate() error {
+	for _, pattern := range []string{c.Hostname, c.Vendor, c.Product, c.Version, c.Device, c.Phys} {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	if c.Variant != "" {
+		if _, ok := Variants[c.Variant]; !ok {
+			return fmt.Errorf("unknown variant %q", c.Variant)
+		}
+	}
+	if op, version := splitComparison(c.Kernel); op != "" && len(versionParts(version)) == 0 {
+		return fmt.Errorf("invalid kernel version %q", c.Kernel)
+	} else if _, err := filepath.Match(c.Kernel, ""); op == "" && err != nil {
+		return fmt.Errorf("invalid pattern %q", c.Kernel)
+	}
+	return nil
+}
+
+// matchKernel matches the kernel release against a glob or a comparison.
+func matchKernel(pattern, release string) bool {
+	op, version := splitComparison(pattern)
+	c := CompareVersions(release, version)
+	switch op {
+	case "":
+		return glob(pattern, release)
+	case ">=":
+		return c >= 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case "<":
+		return c < 0
+	default:
+		return c == 0
+	}
+}
+
+func splitComparison(s string) (op, version string) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(s, op) {
+			return op, strings.TrimSpace(s[len(op):])
+		}
+	}
+	return "", s
+}
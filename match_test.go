@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestConditions_Matches(t *testing.T) {
+	env := &Environment{
+		Hostname: "lab-42",
+		DMI:      DMI{Vendor: "LENOVO", Product: "20S0CTO1WW", Version: "ThinkPad T14 Gen 1"},
+		Device:   &Device{Name: "TPPS/2 Elan TrackPoint", Phys: "isa0060/serio1/serio2/input0", Variant: VariantElan},
+		Kernel:   "5.15.0-91-generic",
+	}
+	tests := []struct {
+		c        Conditions
+		expected bool
+	}{
+		{Conditions{}, true},
+		{Conditions{Hostname: "lab-*", Vendor: "LENOVO", Version: "ThinkPad T14*"}, true},
+		{Conditions{Hostname: "office-*"}, false},
+		{Conditions{Variant: VariantElan, Device: "*Elan*", Phys: "isa0060/*"}, true},
+		{Conditions{Variant: VariantIBM}, false},
+		{Conditions{Kernel: ">=5.4"}, true},
+		{Conditions{Kernel: "<5.4"}, false},
+		{Conditions{Kernel: "5.15.*"}, true},
+		{Conditions{Product: "21*"}, false},
+	}
+	for _, test := range tests {
+		if actual := test.c.Matches(env); actual != test.expected {
+			t.Fatalf("expected %v for %+v, got %v", test.expected, test.c, actual)
+		}
+	}
+
+	for _, c := range []Conditions{{Hostname: "["}, {Variant: "Foo"}, {Kernel: ">=abc"}} {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("expected error for %+v", c)
+		}
+	}
+}
+
+func TestLoadSettingsMatch(t *testing.T) {
+	path, cleanup := writeConfig(t, `sysfs: /dev/null
+values:
+  speed: 1
+  sensitivity: 1
+profiles:
+  precise:
+    sensitivity: 50
+    inertia: 2
+match:
+  - kernel: ">=2.6"
+    profile: precise
+    values:
+      speed: 2
+  - hostname: "no-such-host-*"
+    values:
+      speed: 3
+`)
+	defer cleanup()
+
+	flags, err := ParseFlags([]string{"trackpoint", "--config", path, "--inertia", "9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSettings(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"speed": "2", "sensitivity": "50", "inertia": "9"}
+	for key, value := range expected {
+		if actual := s.Get(key); actual != value {
+			t.Fatalf("expected %v for %v, got %v", value, key, actual)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultModelsDir is the default directory of user defined models.
+	DefaultModelsDir = "/etc/trackpoint/models.d"
+	// BuiltinModelsSource is the source of the built-in models.
+	BuiltinModelsSource = "built-in"
+)
+
+// DMIDir is the directory of the DMI identification of the machine.
+var DMIDir = "/sys/class/dmi/id"
+
+// DMI identifies the machine.
+type DMI struct {
+	Vendor  string // Vendor is the system vendor, e.g. "LENOVO".
+	Product string // Product is the product name, e.g. "20S0CTO1WW".
+	Version string // Version is the product version, e.g. "ThinkPad T14 Gen 1".
+}
+
+// ReadDMI reads the DMI identification of the machine.
+func ReadDMI() DMI {
+	return DMI{
+		Vendor:  readTrimmed(filepath.Join(DMIDir, "sys_vendor")),
+		Product: readTrimmed(filepath.Join(DMIDir, "product_name")),
+		Version: readTrimmed(filepath.Join(DMIDir, "product_version")),
+	}
+}
+
+// Model is an entry of the model database with recommended values.
+type Model struct {
+	Name    string  `yaml:"name"`    // Name identifies the entry.
+	Product string  `yaml:"product"` // Product is a glob matched against the DMI product name.
+	Version string  `yaml:"version"` // Version is a glob matched against the DMI product version.
+	Variant Variant `yaml:"variant"` // Variant is the TrackPoint variant.
+	Values  Values  `yaml:"values"`  // Values are the recommended values.
+	Source  string  `yaml:"-"`       // Source is the file the entry was read from.
+}
+
+// BuiltinModels are the models shipped with the tool. The machine types of
+// the product names are the ones listed in the Lenovo PSREF, the values are
+// recommendations to start from. User defined models take precedence.
+var BuiltinModels = []*Model{
+	{Name: "ThinkPad T14 Gen 1", Product: "20[SU][01DE]*", Version: "ThinkPad T14 Gen 1*", Variant: VariantElan,
+		Values: Values{"sensitivity": 200}},
+	{Name: "ThinkPad T14 Gen 2", Product: "20[WX][01KL]*", Version: "ThinkPad T14 Gen 2*", Variant: VariantElan,
+		Values: Values{"sensitivity": 200}},
+	{Name: "ThinkPad X1 Carbon Gen 7", Product: "20Q[DE]*", Version: "ThinkPad X1 Carbon 7th*", Variant: VariantElan,
+		Values: Values{"sensitivity": 180}},
+	{Name: "ThinkPad X1 Carbon Gen 8", Product: "20U[9A]*", Version: "ThinkPad X1 Carbon Gen 8*", Variant: VariantElan,
+		Values: Values{"sensitivity": 180}},
+	{Name: "ThinkPad X1 Carbon Gen 9", Product: "20X[WX]*", Version: "ThinkPad X1 Carbon Gen 9*", Variant: VariantElan,
+		Values: Values{"sensitivity": 180}},
+	{Name: "ThinkPad P1 Gen 1", Product: "20M[DE]*", Version: "ThinkPad P1", Variant: VariantElan,
+		Values: Values{"sensitivity": 200}},
+	{Name: "ThinkPad P1 Gen 2", Product: "20Q[TU]*", Version: "ThinkPad P1 Gen 2*", Variant: VariantElan,
+		Values: Values{"sensitivity": 200}},
+	{Name: "ThinkPad P1 Gen 3", Product: "20T[HJ]*", Version: "ThinkPad P1 Gen 3*", Variant: VariantElan,
+		Values: Values{"sensitivity": 200}},
+}
+
+func init() {
+	for _, m := range BuiltinModels {
+		m.Source = BuiltinModelsSource
+	}
+}
+
+// LoadModels reads the user defined models from the YAML files in dir,
+// followed by the built-in models. A missing directory is not an error.
+func LoadModels(dir string) ([]*Model, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	var models []*Model
+	for _, file := range files {
+		bytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var m []*Model
+		if err = yaml.UnmarshalStrict(bytes, &m); err != nil {
+			return nil, newConfigErrors(file, bytes, err)
+		}
+		for _, model := range m {
+			model.Source = file
+			if err = model.Validate(); err != nil {
+				return nil, &ConfigError{File: file, Msg: err.Error()}
+			}
+		}
+		models = append(models, m...)
+	}
+	return append(models, BuiltinModels...), nil
+}
+
+// Validate checks that the model identifies the machine by product name and
+// version and the TrackPoint by its variant, as the values depend on all of them.
+func (m *Model) Validate() error {
+	if m.Product == "" || m.Version == "" || m.Variant == "" {
+		return fmt.Errorf("model %q: product, version and variant are required", m.Name)
+	}
+	if _, ok := Variants[m.Variant]; !ok {
+		return fmt.Errorf("model %q: unknown variant %q", m.Name, m.Variant)
+	}
+	return nil
+}
+
+// Matches checks if the model matches the machine and the variant.
+func (m *Model) Matches(dmi DMI, variant Variant) bool {
+	return glob(m.Product, dmi.Product) && glob(m.Version, dmi.Version) && m.Variant == variant
+}
+
+// MatchModel returns the first model matching the machine and the variant or nil.
+func MatchModel(models []*Model, dmi DMI, variant Variant) *Model {
+	for _, m := range models {
+		if m.Matches(dmi, variant) {
+			return m
+		}
+	}
+	return nil
+}
+
+// glob matches s against pattern, an empty pattern matches everything.
+// Unlike filepath.Match a '*' also matches slashes.
+func glob(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	r := strings.NewReplacer("/", "\x00")
+	ok, err := filepath.Match(r.Replace(pattern), r.Replace(s))
+	return err == nil && ok
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchModel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := "- name: My T14\n  product: 20W0*\n  version: ThinkPad T14 Gen 2*\n  variant: Elan\n  values:\n    sensitivity: 99\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, "fleet.yml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	models, err := LoadModels(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != len(BuiltinModels)+1 {
+		t.Fatalf("expected %v models, got %v", len(BuiltinModels)+1, len(models))
+	}
+
+	dmi := DMI{Vendor: "LENOVO", Product: "20W0CTO1WW", Version: "ThinkPad T14 Gen 2i"}
+	m := MatchModel(models, dmi, VariantElan)
+	if m == nil || m.Name != "My T14" || m.Values["sensitivity"] != 99 {
+		t.Fatalf("expected the user defined model, got %v", m)
+	}
+	for _, other := range []struct {
+		dmi     DMI
+		variant Variant
+	}{
+		{dmi, VariantIBM},
+		{DMI{Product: "21AHCTO1WW", Version: dmi.Version}, VariantElan},
+		{DMI{Product: dmi.Product, Version: "ThinkPad E14"}, VariantElan},
+	} {
+		if m = MatchModel(models, other.dmi, other.variant); m != nil {
+			t.Fatalf("expected no model for %v %v, got %v", other.dmi, other.variant, m)
+		}
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "vague.yml"), []byte("- name: T14\n  version: ThinkPad T14*\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadModels(dir); err == nil {
+		t.Fatal("expected error for a model without product and variant")
+	}
+	os.Remove(filepath.Join(dir, "vague.yml"))
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "broken.yml"), []byte("- name: x\n  sensitivity: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadModels(dir); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestMatchModelBuiltin(t *testing.T) {
+	for _, m := range BuiltinModels {
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	models, err := LoadModels(filepath.Join(os.TempDir(), "no-such-models-dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dmi := DMI{Vendor: "LENOVO", Product: "20U9CTO1WW", Version: "ThinkPad X1 Carbon Gen 8"}
+	m := MatchModel(models, dmi, VariantElan)
+	if m == nil || m.Name != "ThinkPad X1 Carbon Gen 8" || m.Source != BuiltinModelsSource {
+		t.Fatalf("expected the built-in X1 Carbon Gen 8, got %v", m)
+	}
+	if m = MatchModel(models, DMI{Product: "20UACTO1WW", Version: "ThinkPad T14 Gen 1"}, VariantElan); m != nil {
+		t.Fatalf("expected no model for a mismatched version, got %v", m)
+	}
+}
+
+func TestLoadSettingsModelDefaults(t *testing.T) {
+	dmiDir, err := ioutil.TempDir("", "trackpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dmiDir)
+	files := map[string]string{
+		"product_name":    "20TH0000US\n",
+		"product_version": "ThinkPad P1 Gen 3\n",
+		"p1.yml":          "- name: ThinkPad P1\n  product: 20TH*\n  version: ThinkPad P1*\n  variant: Elan\n  values:\n    sensitivity: 170\n",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dmiDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(dir string) { DMIDir = dir }(DMIDir)
+	DMIDir = dmiDir
+	base, cleanup := fakeSysfs(t, "TPPS/2 Elan TrackPoint")
+	defer cleanup()
+
+	flags, err := ParseFlags([]string{"trackpoint", "--sysfs", filepath.Join(base, "serio1/serio2"), "--model-defaults",
+		"--models-dir", dmiDir, "--speed", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSettings(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Model == nil || s.Model.Name != "ThinkPad P1" {
+		t.Fatalf("expected model ThinkPad P1, got %v", s.Model)
+	}
+	if s.Get("sensitivity") != "170" || s.Get("speed") != "1" || s.Get("thresh") != "" {
+		t.Fatalf("unexpected values %v", s.ToStringMap())
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+// PowerSupplyDir is the directory of the power supplies.
+var PowerSupplyDir = "/sys/class/power_supply"
+
+// PowerPollInterval is the interval at which the power supplies are polled.
+var PowerPollInterval = 5 * time.Second
+
+// PowerState is the state of the power supply.
+type PowerState int
+
+// The power states.
+const (
+	PowerUnknown PowerState = iota
+	PowerAC
+	PowerBattery
+)
+
+func (p PowerState) String() string {
+	switch p {
+	case PowerAC:
+		return "AC"
+	case PowerBattery:
+		return "battery"
+	default:
+		return "unknown"
+	}
+}
+
+// ReadPowerState reads if the machine runs on AC or on battery. The state
+// is unknown if there are neither mains nor batteries, e.g. on a desktop.
+func ReadPowerState() (PowerState, error) {
+	supplies, err := ioutil.ReadDir(PowerSupplyDir)
+	if err != nil {
+		return PowerUnknown, err
+	}
+	state := PowerUnknown
+	for _, s := range supplies {
+		dir := filepath.Join(PowerSupplyDir, s.Name())
+		switch readTrimmed(filepath.Join(dir, "type")) {
+		case "Mains", "USB":
+			if readTrimmed(filepath.Join(dir, "online")) == "1" {
+				return PowerAC, nil
+			}
+			state = PowerBattery
+		case "Battery":
+			if state == PowerUnknown && readTrimmed(filepath.Join(dir, "status")) == "Discharging" {
+				state = PowerBattery
+			}
+		}
+	}
+	return state, nil
+}
+
+// WatchPower sends the power state initially and whenever it changes,
+// checking on power supply uevents and every PowerPollInterval.
+func WatchPower(hotplug *Hotplug, stop <-chan bool) <-chan PowerState {
+	c := make(chan PowerState, 1)
+	events := hotplug.Subscribe("power_supply")
+	go func() {
+		ticker := time.NewTicker(PowerPollInterval)
+		defer ticker.Stop()
+		last := PowerState(-1)
+		for {
+			if state, err := ReadPowerState(); err == nil && state != last {
+				last = state
+				select {
+				case c <- state:
+				case <-stop:
+					return
+				}
+			}
+			select {
+			case <-stop:
+				return
+			case <-events:
+			case <-ticker.C:
+			}
+		}
+	}()
+	return c
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakePowerSupplies creates a power_supply directory with the given files
+// and points PowerSupplyDir to it.
+func fakePowerSupplies(t *testing.T, files map[string]string) (cleanup func()) {
+	dir, err := ioutil.TempDir("", "trackpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := PowerSupplyDir
+	PowerSupplyDir = dir
+	return func() {
+		PowerSupplyDir = old
+		os.RemoveAll(dir)
+	}
+}
+
+func TestReadPowerState(t *testing.T) {
+	tests := []struct {
+		files    map[string]string
+		expected PowerState
+	}{
+		{map[string]string{}, PowerUnknown},
+		{map[string]string{"AC/type": "Mains", "AC/online": "1", "BAT0/type": "Battery", "BAT0/status": "Charging"}, PowerAC},
+		{map[string]string{"AC/type": "Mains", "AC/online": "0", "BAT0/type": "Battery", "BAT0/status": "Full"}, PowerBattery},
+		{map[string]string{"BAT0/type": "Battery", "BAT0/status": "Discharging"}, PowerBattery},
+		{map[string]string{"BAT0/type": "Battery", "BAT0/status": "Full"}, PowerUnknown},
+	}
+	for _, test := range tests {
+		cleanup := fakePowerSupplies(t, test.files)
+		state, err := ReadPowerState()
+		cleanup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != test.expected {
+			t.Fatalf("expected %v for %v, got %v", test.expected, test.files, state)
+		}
+	}
+}
+
+func TestWatchPower(t *testing.T) {
+	defer fakePowerSupplies(t, map[string]string{"AC/type": "Mains", "AC/online": "1"})()
+	hotplug := NewHotplug()
+	stop := make(chan bool)
+	defer close(stop)
+	power := WatchPower(hotplug, stop)
+
+	expect := func(expected PowerState) {
+		select {
+		case state := <-power:
+			if state != expected {
+				t.Fatalf("expected %v, got %v", expected, state)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %v, got nothing", expected)
+		}
+	}
+	expect(PowerAC)
+	online := filepath.Join(PowerSupplyDir, "AC/online")
+	if err := ioutil.WriteFile(online, []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hotplug.Publish(&Uevent{Action: "change", Subsystem: "power_supply"})
+	expect(PowerBattery)
+}
+
+func TestSettingsDaemon_OverlayRevert(t *testing.T) {
+	dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128", "speed": "97"})
+	defer cleanup()
+	d := newTestDaemon(t, dir)
+	d.Settings.OnBattery = &Overlay{Values: Values{"speed": 80}}
+
+	expect := func(speed string) {
+		if err := d.applySettings(SourceHotplug); err != nil {
+			t.Fatal(err)
+		}
+		if v := readAttribute(t, dir, "speed"); v != speed {
+			t.Fatalf("expected %v, got %v", speed, v)
+		}
+	}
+	// speed is not set in the config, so it goes back to the value of the device
+	d.setPowerState(PowerBattery)
+	expect("80")
+	d.setPowerState(PowerAC)
+	expect("97")
+
+	if resp := d.handleControl(&ControlRequest{Command: "profile", Args: []string{"precise"}}); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	expect("80")
+	if resp := d.handleControl(&ControlRequest{Command: "profile", Args: []string{NoProfile}}); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	expect("97")
+}
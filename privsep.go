@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// helperFD is the file descriptor of the socket the helper inherits.
+const helperFD = 3
+
+var (
+	// ErrNotRoot indicates that the privileges cannot be separated without root.
+	ErrNotRoot = errors.New("separating privileges needs root")
+)
+
+// helperRequest asks the helper to write a value to an attribute.
+type helperRequest struct {
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+// helperResponse is the answer of the helper.
+type helperResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Helper is the connection of the daemon to the privileged helper, which
+// writes the attributes on its behalf after it dropped its privileges.
+type Helper struct {
+	sync.Mutex
+	conn *net.UnixConn
+	cmd  *exec.Cmd
+}
+
+// StartHelper starts the privileged helper as a child process that is
+// connected over a socketpair.
+func StartHelper() (*Helper, error) {
+	fds, err := socketpair()
+	if err != nil {
+		return nil, err
+	}
+	local, remote := fds[0], fds[1]
+	defer remote.Close()
+	defer local.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, "helper")
+	cmd.ExtraFiles = []*os.File{remote}
+	cmd.Stderr = os.Stderr
+	// keep the helper out of the process group, so a ^C does not stop it
+	// before the daemon restored the values; it exits once the daemon closed
+	// the socket
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	conn, err := net.FileConn(local)
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+	return &Helper{conn: conn.(*net.UnixConn), cmd: cmd}, nil
+}
+
+// socketpair returns a pair of connected sockets that keep the boundaries
+// of the messages.
+func socketpair() ([2]*os.File, error) {
+	// not every system knows SOCK_CLOEXEC, the lock keeps the sockets from
+	// leaking into other children until they are closed on exec
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return [2]*os.File{}, err
+	}
+	return [2]*os.File{os.NewFile(uintptr(fds[0]), "helper"), os.NewFile(uintptr(fds[1]), "helper")}, nil
+}
+
+// Write asks the helper to write the value to the attribute at path.
+func (h *Helper) Write(path, value string) error {
+	h.Lock()
+	defer h.Unlock()
+	data, err := json.Marshal(helperRequest{path, value})
+	if err != nil {
+		return err
+	}
+	if _, err = h.conn.Write(data); err != nil {
+		return err
+	}
+	buf := make([]byte, 4096)
+	n, err := h.conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	var resp helperResponse
+	if err = json.Unmarshal(buf[:n], &resp); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+// Close stops the helper.
+func (h *Helper) Close() error {
+	err := h.conn.Close()
+	if h.cmd != nil {
+		h.cmd.Wait()
+	}
+	return err
+}
+
+// ServeHelper answers the write requests on conn until it is closed. Only
+// the known attributes of TrackPoint devices below base are written.
+func ServeHelper(conn *net.UnixConn, base string, write func(path, value string) error) error {
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err == io.EOF || (err == nil && n == 0) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var req helperRequest
+		var resp helperResponse
+		if err = json.Unmarshal(buf[:n], &req); err != nil {
+			resp.Error = err.Error()
+		} else if path, err := allowedWrite(base, req.Path, req.Value); err != nil {
+			log.Printf("helper: refused %q to %v: %v", req.Value, req.Path, err)
+			resp.Error = err.Error()
+		} else if err = write(path, req.Value); err != nil {
+			resp.Error = err.Error()
+		}
+		data, err := json.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		if _, err = conn.Write(data); err != nil {
+			return err
+		}
+	}
+}
+
+// allowedWrite checks that path is a known attribute of a TrackPoint below
+// base and value is valid for it, and returns the resolved path.
+func allowedWrite(base, path, value string) (string, error) {
+	dir, key := filepath.Split(filepath.Clean(path))
+	a := LookupAttribute(key)
+	if a == nil || a.Name != key {
+		return "", fmt.Errorf("%s is not a known attribute", key)
+	}
+	if _, err := a.Parse(value); err != nil {
+		return "", err
+	}
+	dir, err := checkDevice(base, dir)
+	if err != nil {
+		return "", err
+	}
+	path = filepath.Join(dir, key)
+	if info, err := os.Lstat(path); err != nil {
+		return "", err
+	} else if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", path)
+	}
+	return path, nil
+}
+
+// checkDevice checks that dir is a TrackPoint below base and returns it with
+// the links resolved.
+func checkDevice(base, dir string) (string, error) {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(dir, filepath.Clean(base)+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not below %s", dir, base)
+	}
+	if d := ReadDevice(dir); !strings.Contains(d.Name, TrackPointName) {
+		return "", fmt.Errorf("%s is not a TrackPoint", dir)
+	}
+	return dir, nil
+}
+
+// checkKey checks that key names a known or an extra attribute, which keeps
+// keys read from the state directory from pointing outside the device.
+func checkKey(key string) error {
+	if LookupAttribute(key) == nil && !extraNamePattern.MatchString(key) {
+		return fmt.Errorf("%q is not a valid attribute name", key)
+	}
+	return nil
+}
+
+// openStateFile opens a file of the state directory without following a
+// link. As the daemon hands the state directory to its user, root refuses
+// the files it does not own.
+func openStateFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil || os.Geteuid() != 0 {
+		return f, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); !ok || st.Uid != 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s is not owned by root, refusing it", path)
+	}
+	return f, nil
+}
+
+// readStateFile reads a file of the state directory like openStateFile.
+func readStateFile(path string) ([]byte, error) {
+	f, err := openStateFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// runHelper serves the daemon that started it on the inherited socket.
+func runHelper(args []string) error {
+	if len(args) != 0 {
+		return ErrUsage
+	}
+	// the daemon may still need the helper to restore the values when it is
+	// stopped; the helper exits once the daemon closed the socket
+	signal.Ignore(syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	conn, err := net.FileConn(os.NewFile(helperFD, "helper"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	rw := NewSettingsReaderWriter(SysfsBaseDir)
+	return ServeHelper(conn.(*net.UnixConn), SysfsBaseDir, rw.writeValue)
+}
+
+// SeparatePrivileges starts the privileged helper for the writes of the
+// daemon, hands the state and runtime directories and the log file to the
+// user and drops the privileges of the process to it. Root refuses the state
+// files the user owns from then on, see openStateFile.
+func (d *SettingsDaemon) SeparatePrivileges(name string) error {
+	if os.Getuid() != 0 {
+		return ErrNotRoot
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{d.Settings.StateDir, d.Settings.RunDir} {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err = chownAll(dir, uid, gid); err != nil {
+			return err
+		}
+	}
+	if path := d.Settings.logFile(); path != "" {
+		if err = os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	h, err := StartHelper()
+	if err != nil {
+		return err
+	}
+	d.UseHelper(h)
+	if err = dropPrivileges(u, uid, gid); err != nil {
+		h.Close()
+		return err
+	}
+	log.Printf("dropped privileges to %v, writing through the helper", name)
+	return nil
+}
+
+// chownAll hands the directory and the files in it, e.g. the history of a
+// previous run as root, to the user. Links are not followed.
+func chownAll(dir string, uid, gid int) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+func dropPrivileges(u *user.User, uid, gid int) error {
+	ids, err := u.GroupIds()
+	if err != nil {
+		return err
+	}
+	groups := []int{gid}
+	for _, id := range ids {
+		if g, err := strconv.Atoi(id); err == nil && g != gid {
+			groups = append(groups, g)
+		}
+	}
+	if err = syscall.Setgroups(groups); err != nil {
+		return err
+	}
+	if err = syscall.Setgid(gid); err != nil {
+		return err
+	}
+	return syscall.Setuid(uid)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func unixConnPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	var conns [2]*net.UnixConn
+	fds, err := socketpair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range fds {
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = c.(*net.UnixConn)
+	}
+	return conns[0], conns[1]
+}
+
+func TestHelper(t *testing.T) {
+	base, cleanup := fakeSysfs(t, "TPPS/2 IBM TrackPoint")
+	defer cleanup()
+	trackpoint, touchpad := filepath.Join(base, "serio1/serio2"), filepath.Join(base, "serio1")
+	for _, path := range []string{filepath.Join(trackpoint, "new_thing"), filepath.Join(touchpad, "sensitivity")} {
+		if err := ioutil.WriteFile(path, []byte("0\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outside, err := ioutil.TempDir("", "outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	client, server := unixConnPair(t)
+	written := make(map[string]string)
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeHelper(server, base, func(path, value string) error {
+			written[path] = value
+			return nil
+		})
+	}()
+	h := &Helper{conn: client}
+
+	allowed := filepath.Join(trackpoint, "sensitivity")
+	if err = h.Write(filepath.Join(trackpoint, "input", "..", "sensitivity"), "200"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		filepath.Join(trackpoint, "new_thing"),
+		filepath.Join(touchpad, "sensitivity"),
+		filepath.Join(outside, "sensitivity"),
+	} {
+		if err = h.Write(path, "1"); err == nil {
+			t.Fatalf("expected error for %v", path)
+		}
+	}
+	if err = h.Write(allowed, "256"); err == nil {
+		t.Fatal("expected error for an invalid value")
+	}
+	if err = h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || written[allowed] != "200" {
+		t.Fatalf("expected only %v to be written, got %v", allowed, written)
+	}
+}
+
+func TestChownAll(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner needs root")
+	}
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outside, err := ioutil.TempFile("", "outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside.Close()
+	defer os.Remove(outside.Name())
+	history := filepath.Join(dir, "history.jsonl")
+	if err = ioutil.WriteFile(history, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(outside.Name(), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = chownAll(dir, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	for path, uid := range map[string]uint32{dir: 65534, history: 65534, outside.Name(): 0} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := info.Sys().(*syscall.Stat_t).Uid; actual != uid {
+			t.Fatalf("expected %v for %v, got %v", uid, path, actual)
+		}
+	}
+}
+
+func TestReadStateFile(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner needs root")
+	}
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	originals := "device: /sys/devices/platform/i8042/serio1/serio2\nvalues:\n  speed: \"97\"\n"
+	if err = ioutil.WriteFile(originalsPath(dir), []byte(originals), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if o, err := LoadOriginals(dir); err != nil || o.Values["speed"] != "97" {
+		t.Fatalf("expected the originals, got %v, %v", o, err)
+	}
+
+	// root does not trust the files of the user
+	if err = os.Chown(originalsPath(dir), 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadOriginals(dir); err == nil {
+		t.Fatal("expected error for originals of another user")
+	}
+	link := filepath.Join(dir, "link")
+	if err = os.Symlink(originalsPath(dir), link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = readStateFile(link); err == nil {
+		t.Fatal("expected error for a link")
+	}
+}
+
+func TestCheckStateEntries(t *testing.T) {
+	base, cleanup := fakeSysfs(t, "TPPS/2 IBM TrackPoint")
+	defer cleanup()
+	if _, err := checkDevice(base, filepath.Join(base, "serio1/serio2")); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{filepath.Join(base, "serio1"), os.TempDir()} {
+		if _, err := checkDevice(base, dir); err == nil {
+			t.Fatalf("expected error for %v", dir)
+		}
+	}
+	e := &HistoryEntry{Values: map[string]string{"speed": "97", "../../../etc/passwd": "x"}}
+	if _, _, err := e.Settings(); err == nil {
+		t.Fatal("expected error for a key outside the device")
+	}
+}
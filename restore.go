@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Originals are the values the device had before the daemon started.
+type Originals struct {
+	Device string            `yaml:"device"` // Device is the SYSFS path of the device.
+	Values map[string]string `yaml:"values"` // Values are the values of the attributes.
+}
+
+func originalsPath(stateDir string) string {
+	return filepath.Join(stateDir, "originals.yml")
+}
+
+// LoadOriginals reads the originals persisted in the state directory. It
+// returns nil if there are none.
+func LoadOriginals(stateDir string) (*Originals, error) {
+	bytes, err := readStateFile(originalsPath(stateDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var o Originals
+	if err = yaml.UnmarshalStrict(bytes, &o); err != nil {
+		return nil, err
+	}
+	for key := range o.Values {
+		if err = checkKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return &o, nil
+}
+
+// Save persists the originals in the state directory.
+func (o *Originals) Save(stateDir string) error {
+	bytes, err := yaml.Marshal(o)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(stateDir, 0755); err != nil {
+		return err
+	}
+	path := originalsPath(stateDir)
+	if err = ioutil.WriteFile(path+".tmp", bytes, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// RemoveOriginals removes the originals persisted in the state directory.
+func RemoveOriginals(stateDir string) error {
+	if err := os.Remove(originalsPath(stateDir)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// captureOriginals snapshots the device values before they are changed
+// for the first time. Originals left behind by a crashed daemon are kept,
+// as the device still has the values that daemon wrote.
+func (d *SettingsDaemon) captureOriginals() error {
+	d.Lock()
+	defer d.Unlock()
+	if !d.Settings.RestoreOnExit {
+		return RemoveOriginals(d.Settings.StateDir)
+	}
+	o, err := LoadOriginals(d.Settings.StateDir)
+	if err != nil {
+		return err
+	}
+	if o != nil {
+		log.Printf("restoring the originals of a previous run on exit")
+		d.originals = o
+		return nil
+	}
+	values, err := d.rw.Snapshot(d.Settings.DeviceKeys())
+	if err != nil {
+		return err
+	}
+	o = &Originals{Device: d.Settings.SysfsPath, Values: values}
+	if err = o.Save(d.Settings.StateDir); err != nil {
+		return err
+	}
+	d.originals = o
+	return nil
+}
+
+// Shutdown writes the original values back to the device if restore_on_exit
+// is enabled. It is called by Run when the daemon is stopped gracefully.
+// Every key is tried; the keys that fail are reported as *ApplyError and
+// kept in the originals for the next run.
+func (d *SettingsDaemon) Shutdown() error {
+	d.writing.Lock()
+	defer d.writing.Unlock()
+	d.Lock()
+	defer d.Unlock()
+	o := d.originals
+	if o == nil || !d.Settings.RestoreOnExit {
+		return nil
+	}
+	log.Printf("restoring the original values")
+	rw := d.rw
+	if o.Device != d.Settings.SysfsPath {
+		// the device of a previous run is only trusted if it is a TrackPoint
+		device, err := checkDevice(SysfsBaseDir, o.Device)
+		if err != nil {
+			return err
+		}
+		rw = d.newReaderWriter(device)
+	}
+	keys := make([]string, 0, len(o.Values))
+	for key := range o.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	before, _ := rw.Snapshot(keys)
+	failed := make(map[string]error)
+	for _, key := range keys {
+		if err := rw.SetValue(key, o.Values[key]); err != nil {
+			failed[key] = err
+		}
+	}
+	d.history.Record(SourceExit, rw, keys, before)
+	if len(failed) == 0 {
+		d.originals = nil
+		return RemoveOriginals(d.Settings.StateDir)
+	}
+	// the next run restores the keys that could not be restored now
+	for key := range o.Values {
+		if _, ok := failed[key]; !ok {
+			delete(o.Values, key)
+		}
+	}
+	if err := o.Save(d.Settings.StateDir); err != nil {
+		log.Printf("could not save the originals: %v", err)
+	}
+	return &ApplyError{Failed: failed}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestSettingsDaemon_RestoreOnExit(t *testing.T) {
+	dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128", "speed": "97"})
+	defer cleanup()
+	stateDir, err := ioutil.TempDir("", "trackpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	start := func(sensitivity uint8) *SettingsDaemon {
+		s := newSettings()
+		s.SysfsPath, s.StateDir, s.RestoreOnExit = dir, stateDir, true
+		s.Values = Values{"sensitivity": sensitivity, "speed": 100}
+		d := NewSettingsDaemon(s)
+		fakeWrites(d.rw)
+		if err := d.captureOriginals(); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.applySettings(SourceStartup); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	// the second daemon starts after the first one crashed
+	start(200)
+	d := start(220)
+	if v := readAttribute(t, dir, "sensitivity"); v != "220" {
+		t.Fatalf("expected %v, got %v", "220", v)
+	}
+	if err = d.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"sensitivity": "128", "speed": "97"}
+	for key, value := range expected {
+		if v := readAttribute(t, dir, key); v != value {
+			t.Fatalf("expected %v for %v, got %v", value, key, v)
+		}
+	}
+	if o, err := LoadOriginals(stateDir); err != nil || o != nil {
+		t.Fatalf("expected the originals to be removed, got %v, %v", o, err)
+	}
+}
+
+func TestSettingsDaemon_RestoreOnExitFailure(t *testing.T) {
+	dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128", "speed": "97"})
+	defer cleanup()
+	stateDir, err := ioutil.TempDir("", "trackpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	s := newSettings()
+	s.SysfsPath, s.StateDir, s.RestoreOnExit = dir, stateDir, true
+	s.Values = Values{"sensitivity": 200, "speed": 100}
+	d := NewSettingsDaemon(s)
+	fakeWrites(d.rw)
+	if err = d.captureOriginals(); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.applySettings(SourceStartup); err != nil {
+		t.Fatal(err)
+	}
+	write := d.rw.write
+	d.rw.write = func(path, value string) error {
+		if filepath.Base(path) == "sensitivity" {
+			return syscall.EIO
+		}
+		return write(path, value)
+	}
+
+	e, ok := d.Shutdown().(*ApplyError)
+	if !ok || len(e.Failed) != 1 || e.Failed["sensitivity"] == nil {
+		t.Fatalf("expected sensitivity to fail, got %v", e)
+	}
+	if v := readAttribute(t, dir, "speed"); v != "97" {
+		t.Fatalf("expected %v, got %v", "97", v)
+	}
+	o, err := LoadOriginals(stateDir)
+	if err != nil || o == nil || len(o.Values) != 1 || o.Values["sensitivity"] != "128" {
+		t.Fatalf("expected the originals of sensitivity to be kept, got %v, %v", o, err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The access rights of Landlock by ABI version.
+const (
+	landlockRead  = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	landlockWrite = landlockRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE
+	landlockOwn   = landlockWrite | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_REFER | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	landlockABI1 = 1<<13 - 1
+)
+
+// Sandbox restricts the process to what the daemon needs: Landlock limits
+// the file system to the config, the SYSFS and the state and runtime
+// directories, all capabilities are dropped and seccomp denies all system
+// calls but the SandboxAllowedSyscalls. Parts the kernel or a binary using cgo do not
+// support are skipped with a log message. Paths that change with a reload
+// need a restart.
+func Sandbox(s *Settings) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	err := allThreads(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0)
+	if err == syscall.ENOTSUP {
+		// seccomp still covers all threads, it takes no_new_privs along
+		log.Print("sandbox: the binary uses cgo, Landlock and dropping capabilities need a build with CGO_ENABLED=0")
+		if err = unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err == nil {
+			err = seccomp(SandboxAllowedSyscalls)
+		}
+		if err != nil {
+			log.Printf("sandbox: system calls not restricted: %v", err)
+		}
+		return
+	} else if err != nil {
+		log.Printf("sandbox: cannot set no_new_privs, not sandboxing: %v", err)
+		return
+	}
+	if err := landlock(sandboxPaths(s)); err != nil {
+		log.Printf("sandbox: file system not restricted: %v", err)
+	}
+	// a user other than root has no capabilities to drop
+	if os.Geteuid() == 0 {
+		if err := dropCapabilities(); err != nil {
+			log.Printf("sandbox: capabilities not dropped: %v", err)
+		}
+	}
+	if err := seccomp(SandboxAllowedSyscalls); err != nil {
+		log.Printf("sandbox: system calls not restricted: %v", err)
+	}
+}
+
+// allThreads runs the system call on all threads, as the credentials,
+// Landlock domains and bounding sets are per thread. It does not work with
+// cgo.
+func allThreads(trap, a1, a2, a3 uintptr) error {
+	if _, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// sandboxPaths maps the paths the daemon uses to the access it needs.
+func sandboxPaths(s *Settings) map[string]uint64 {
+	paths := map[string]uint64{"/sys": landlockRead, ProcDir: landlockRead, s.ModelsDir: landlockRead}
+	if s.Path != "" {
+		paths[filepath.Dir(s.Path)] = landlockRead
+	}
+	for _, source := range ManagerSources {
+		paths[filepath.Dir(source.Pattern)] = landlockRead
+	}
+	// the units in /etc are mostly links to those of the packages
+	for _, dir := range []string{"/usr/lib/systemd/system", "/lib/systemd/system"} {
+		paths[dir] = landlockRead
+	}
+	paths[SysfsBaseDir] = landlockWrite
+	if s.SysfsPath != "" {
+		paths[s.SysfsPath] = landlockWrite
+	}
+	if path := s.logFile(); path != "" {
+		// the log file is created again after it was rotated
+		paths[filepath.Dir(path)] = landlockWrite | unix.LANDLOCK_ACCESS_FS_MAKE_REG
+	}
+	paths[s.StateDir] = landlockOwn
+	paths[s.RunDir] = landlockOwn
+	return paths
+}
+
+// landlockAccess returns the file system access rights of the ABI version.
+func landlockAccess(abi int) uint64 {
+	access := uint64(landlockABI1)
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	return access
+}
+
+func landlock(paths map[string]uint64) error {
+	abi, _, errno := syscall.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return errno
+	}
+	handled := landlockAccess(int(abi))
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := syscall.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errno
+	}
+	defer syscall.Close(int(fd))
+	for path, access := range paths {
+		dir, err := os.OpenFile(path, unix.O_PATH|syscall.O_CLOEXEC, 0)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		rule := unix.LandlockPathBeneathAttr{Allowed_access: access & handled, Parent_fd: int32(dir.Fd())}
+		_, _, errno = syscall.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, fd, unix.LANDLOCK_RULE_PATH_BENEATH,
+			uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+		dir.Close()
+		if errno != 0 {
+			return &os.PathError{Op: "landlock", Path: path, Err: errno}
+		}
+	}
+	return allThreads(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0)
+}
+
+func dropCapabilities() error {
+	for c := 0; c <= unix.CAP_LAST_CAP; c++ {
+		if err := allThreads(unix.SYS_PRCTL, unix.PR_CAPBSET_DROP, uintptr(c), 0); err != nil && err != syscall.EINVAL {
+			return err
+		}
+	}
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	return allThreads(unix.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0)
+}
+
+// seccompFilter returns a filter that fails all but the allowed system calls
+// and those of other architectures with EPERM.
+func seccompFilter(arch uint32, allowed []uint32) []unix.SockFilter {
+	n := len(allowed)
+	errno := unix.SECCOMP_RET_ERRNO | uint32(syscall.EPERM)
+	filter := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: 4}, // arch
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, K: arch},
+		{Code: unix.BPF_RET | unix.BPF_K, K: errno},
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: 0}, // nr
+		// the x32 system calls of amd64
+		{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jt: uint8(n), K: 0x40000000},
+	}
+	for i, nr := range allowed {
+		filter = append(filter, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: uint8(n - i), K: nr})
+	}
+	return append(filter,
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: errno},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_ALLOW})
+}
+
+// seccomp denies all but the allowed system calls on all threads. It is not
+// supported on architectures without a list of the system calls.
+func seccomp(allowed []uint32) error {
+	if sandboxAuditArch == 0 || len(allowed) == 0 {
+		return syscall.ENOTSUP
+	}
+	filter := seccompFilter(sandboxAuditArch, allowed)
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	_, _, errno := syscall.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// sandboxAuditArch is the audit architecture checked by the seccomp filter.
+const sandboxAuditArch = unix.AUDIT_ARCH_AARCH64
+
+// archSyscalls are the system calls of the architecture that others have
+// under a different name or not at all.
+var archSyscalls = []uint32{unix.SYS_FSTATAT}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// runFilter evaluates the instructions of seccompFilter for a system call.
+func runFilter(t *testing.T, filter []unix.SockFilter, arch, nr uint32) uint32 {
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		f := filter[pc]
+		switch f.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			acc = nr
+			if f.K == 4 {
+				acc = arch
+			}
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			if acc == f.K {
+				pc += int(f.Jt)
+			} else {
+				pc += int(f.Jf)
+			}
+		case unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			if acc >= f.K {
+				pc += int(f.Jt)
+			} else {
+				pc += int(f.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return f.K
+		default:
+			t.Fatalf("unexpected instruction %+v", f)
+		}
+	}
+	t.Fatal("expected a return")
+	return 0
+}
+
+func TestSeccompFilter(t *testing.T) {
+	filter := seccompFilter(unix.AUDIT_ARCH_X86_64, []uint32{unix.SYS_READ, unix.SYS_WRITE, unix.SYS_EXIT_GROUP})
+	deny := uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))
+	tests := []struct {
+		arch, nr uint32
+		expected uint32
+	}{
+		{unix.AUDIT_ARCH_X86_64, unix.SYS_READ, unix.SECCOMP_RET_ALLOW},
+		{unix.AUDIT_ARCH_X86_64, unix.SYS_WRITE, unix.SECCOMP_RET_ALLOW},
+		{unix.AUDIT_ARCH_X86_64, unix.SYS_EXIT_GROUP, unix.SECCOMP_RET_ALLOW},
+		{unix.AUDIT_ARCH_X86_64, unix.SYS_PTRACE, deny},
+		{unix.AUDIT_ARCH_X86_64, unix.SYS_FSOPEN, deny},
+		{unix.AUDIT_ARCH_X86_64, 0x40000000 | unix.SYS_READ, deny},
+		{unix.AUDIT_ARCH_I386, unix.SYS_READ, deny},
+	}
+	for _, test := range tests {
+		if actual := runFilter(t, filter, test.arch, test.nr); actual != test.expected {
+			t.Fatalf("expected %x for %v/%v, got %x", test.expected, test.arch, test.nr, actual)
+		}
+	}
+}
+
+func TestSandboxAllowedSyscalls(t *testing.T) {
+	denied := []uint32{
+		unix.SYS_EXECVE, unix.SYS_EXECVEAT, unix.SYS_PTRACE, unix.SYS_MOUNT, unix.SYS_FSOPEN,
+		unix.SYS_FSMOUNT, unix.SYS_MOVE_MOUNT, unix.SYS_OPEN_TREE, unix.SYS_IO_URING_SETUP,
+		unix.SYS_IO_URING_ENTER, unix.SYS_IO_URING_REGISTER, unix.SYS_PIDFD_GETFD, unix.SYS_BPF,
+		unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_KEXEC_LOAD, unix.SYS_UNSHARE, unix.SYS_SETNS,
+		unix.SYS_PROCESS_VM_WRITEV, unix.SYS_USERFAULTFD, unix.SYS_SECCOMP, unix.SYS_PRCTL,
+	}
+	for _, nr := range denied {
+		for _, allowed := range SandboxAllowedSyscalls {
+			if nr == allowed {
+				t.Fatalf("expected system call %v to be denied", nr)
+			}
+		}
+	}
+}
+
+func TestLandlockAccess(t *testing.T) {
+	if access := landlockAccess(1); access&unix.LANDLOCK_ACCESS_FS_REFER != 0 || access&unix.LANDLOCK_ACCESS_FS_MAKE_SYM == 0 {
+		t.Fatalf("expected the rights of ABI 1, got %x", access)
+	}
+	if access := landlockAccess(3); access&unix.LANDLOCK_ACCESS_FS_TRUNCATE == 0 {
+		t.Fatalf("expected truncate in ABI 3, got %x", access)
+	}
+}
+
+func TestSandboxPaths(t *testing.T) {
+	s := newSettings()
+	s.Path = "/etc/trackpoint/trackpoint.yml"
+	s.StateDir = "/var/lib/trackpoint"
+	paths := sandboxPaths(s)
+	expected := map[string]uint64{
+		"/etc/trackpoint":     landlockRead,
+		"/proc":               landlockRead,
+		SysfsBaseDir:          landlockWrite,
+		"/var/lib/trackpoint": landlockOwn,
+		DefaultRunDir:         landlockOwn,
+	}
+	for path, access := range expected {
+		if paths[path] != access {
+			t.Fatalf("expected %x for %v, got %x", access, path, paths[path])
+		}
+	}
+	if access := paths["/sys"]; access&unix.LANDLOCK_ACCESS_FS_WRITE_FILE != 0 {
+		t.Fatalf("expected /sys to be read only, got %x", access)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build linux && !amd64 && !arm64
+
+package main
+
+// sandboxAuditArch is 0 as there is no list of the system calls for the
+// architecture, which skips seccomp.
+const sandboxAuditArch = 0
+
+// SandboxAllowedSyscalls are not known for the architecture.
+var SandboxAllowedSyscalls []uint32
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "log"
+
+// Sandbox only logs that sandboxing needs Linux.
+func Sandbox(s *Settings) {
+	log.Print("sandbox: not supported on this system")
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
-	"errors"
+	"fmt"
 	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
 	"reflect"
-	"strconv"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -12,115 +17,506 @@ import (
 
 // Settings are the configurable TrackPoint settings.
 type Settings struct {
-	Path      string        // Path is the path to the settings
-	SysfsPath string        `yaml:"sysfs"`    // SysfsPath is the path to the SYSFS device.
-	Values    *Values       `yaml:"values"`   // Values are the trackpoint properties.
-	Daemon    bool          `yaml:"daemon"`   // Daemon lets the tool act as a daemon.
-	Interval  time.Duration `yaml:"interval"` // Interval is the interval at which the daemon executes.
-}
-
-// Values are the configurable values.
-type Values struct {
-	DragHysteresis uint8 `yaml:"draghys" trackpoint:"draghys"`                 // Drag Hysteresis (how hard it is to drag with Z-axis pressed).
-	Threshold      uint8 `yaml:"thresh" trackpoint:"thresh"`                   // Minimum value for a Z-axis press.
-	UpThreshold    uint8 `yaml:"upthresh" trackpoint:"upthresh"`               // Used to generate a 'click' on Z-axis.
-	ZTime          uint8 `yaml:"ztime" trackpoint:"ztime"`                     // How sharp of a press.
-	Sensitivity    uint8 `yaml:"sensitivity" trackpoint:"sensitivity"`         // Sensitivity.
-	Inertia        uint8 `yaml:"inertia" trackpoint:"inertia"`                 // Negative Inertia.
-	Speed          uint8 `yaml:"speed" trackpoint:"speed"`                     // Speed of TP Cursor.
-	Reach          uint8 `yaml:"reach" trackpoint:"reach"`                     // Backup for Z-axis press.
-	MinDrag        uint8 `yaml:"mindrag" trackpoint:"mindrag"`                 // Minimum amount of force needed to trigger dragging.
-	Jenks          uint8 `yaml:"jenks" trackpoint:"jenks"`                     // Minimum curvature for double click.
-	DriftTime      uint8 `yaml:"drift_time" trackpoint:"drift_time"`           // How long a 'hands off' condition must last for drift correction to occur.
-	PressToSelect  bool  `yaml:"press_to_select" trackpoint:"press_to_select"` // Press to Select.
-	Skipback       bool  `yaml:"skipback" trackpoint:"skipback"`               // Suppress movement after drag release.
-	ExternalDevice bool  `yaml:"ext_dev" trackpoint:"ext_dev"`                 // Disable external device.
-
+	Path          string        `yaml:"-"`               // Path is the path to the settings
+	SysfsPath     string        `yaml:"sysfs"`           // SysfsPath is the path to the SYSFS device.
+	Device        *Device       `yaml:"-"`               // Device is the TrackPoint at SysfsPath.
+	Values        Values        `yaml:"values"`          // Values are the trackpoint properties.
+	Extra         Extra         `yaml:"extra"`           // Extra are attributes that are written as they are.
+	Profiles      Profiles      `yaml:"profiles"`        // Profiles are named sets of values.
+	Match         []*MatchBlock `yaml:"match"`           // Match are blocks applied if their conditions match.
+	OnAC          *Overlay      `yaml:"on_ac"`           // OnAC is applied while running on AC.
+	OnBattery     *Overlay      `yaml:"on_battery"`      // OnBattery is applied while running on battery.
+	OnMouse       *Overlay      `yaml:"on_mouse"`        // OnMouse is applied while an external pointing device is present.
+	ManageExtDev  bool          `yaml:"manage_ext_dev"`  // ManageExtDev enables the passthrough port only while an external pointing device is present.
+	Libinput      *Libinput     `yaml:"libinput"`        // Libinput are the settings of libinput and the hwdb.
+	User          string        `yaml:"user"`            // User is the user the daemon drops its privileges to.
+	Sandbox       bool          `yaml:"sandbox"`         // Sandbox restricts the daemon with Landlock, seccomp and capabilities.
+	Detach        bool          `yaml:"detach"`          // Detach runs the daemon in the background.
+	LogFile       string        `yaml:"log_file"`        // LogFile is the file the log goes to instead of stderr.
+	Daemon        bool          `yaml:"daemon"`          // Daemon lets the tool act as a daemon.
+	Interval      time.Duration `yaml:"interval"`        // Interval is the interval at which the daemon executes.
+	Trial         time.Duration `yaml:"trial"`           // Trial is how long a reloaded config is tried before it is reverted unless confirmed, 0 disables it.
+	Unset         string        `yaml:"unset"`           // Unset is the policy for values that are not set, UnsetDefault or UnsetIgnore.
+	ApplyMode     string        `yaml:"apply_mode"`      // ApplyMode is ApplyBestEffort or ApplyAllOrNothing.
+	RestoreOnExit bool          `yaml:"restore_on_exit"` // RestoreOnExit restores the values the device had before the daemon started.
+	ModelDefaults bool          `yaml:"model_defaults"`  // ModelDefaults enables the model database underneath Values.
+	ModelsDir     string        `yaml:"models_dir"`      // ModelsDir is the directory of user defined models.
+	Model         *Model        `yaml:"-"`               // Model is the matched entry of the model database.
+	Env           *Environment  `yaml:"-"`               // Env is the environment the settings were resolved for.
+	StateDir      string        `yaml:"-"`               // StateDir is the directory for persistent state.
+	RunDir        string        `yaml:"-"`               // RunDir is the directory for runtime files like the control socket.
+	Flags         *Flags        `yaml:"-"`               // Flags are the command line options the settings were loaded with.
+	source        []byte        // source is the content of the config file.
 }
 
+// The policies for values that are not set.
+const (
+	UnsetDefault = "default" // UnsetDefault enforces the default for values that are not set.
+	UnsetIgnore  = "ignore"  // UnsetIgnore leaves values that are not set untouched.
+)
+
+// The modes of writing the values to the device.
+const (
+	ApplyBestEffort   = "best-effort"    // ApplyBestEffort keeps the values that could be written.
+	ApplyAllOrNothing = "all-or-nothing" // ApplyAllOrNothing restores the previous values if any value fails.
+)
+
+// Values are the configured attribute values by attribute name. Attributes
+// that are not contained are not managed.
+type Values map[string]uint8
+
+// Extra are additional attributes by name that are not described by
+// Attributes. Their values are written as they are.
+type Extra map[string]string
+
+// Profiles are named sets of values.
+type Profiles map[string]Values
+
+var extraNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
 // NewSettings creates a new Settings with default values.
 func NewSettings() *Settings {
-	s := &Settings{Values: &Values{}}
-	s.Values.SetDefaults()
+	s := newSettings()
+	s.Values.SetDefaults(VariantIBM)
 	return s
 }
 
-// SetDefaults resets the settings.
-func (s *Values) SetDefaults() {
-	s.DragHysteresis = DefaultDragHysteresis
-	s.Threshold = DefaultThreshold
-	s.UpThreshold = DefaultUpThreshold
-	s.ZTime = DefaultZTime
-	s.Sensitivity = DefaultSensitivity
-	s.Inertia = DefaultInertia
-	s.Speed = DefaultSpeed
-	s.Reach = DefaultReach
-	s.MinDrag = DefaultMinDrag
-	s.Jenks = DefaultJenks
-	s.DriftTime = DefaultDriftTime
-	s.PressToSelect = DefaultPressToSelect
-	s.Skipback = DefaultSkipback
-	s.ExternalDevice = DefaultExternalDevice
+// newSettings creates a new Settings without any values set.
+func newSettings() *Settings {
+	return &Settings{
+		Values:    make(Values),
+		Interval:  DefaultInterval,
+		Unset:     UnsetDefault,
+		ApplyMode: ApplyBestEffort,
+		ModelsDir: DefaultModelsDir,
+		StateDir:  DefaultStateDir,
+		RunDir:    DefaultRunDir,
+	}
+}
+
+// LoadSettings builds the effective settings from scratch: first the
+// defaults of the variant, then the model defaults if enabled, then the
+// config file, the matching blocks of it and at last the command line flags.
+func LoadSettings(flags *Flags) (*Settings, error) {
+	return loadSettings(flags, flags.Config, ioutil.ReadFile)
+}
+
+// LoadLastKnownGood loads the settings like LoadSettings, but uses the
+// persisted copy of the last config file that was successfully loaded.
+func LoadLastKnownGood(flags *Flags) (*Settings, error) {
+	s, err := loadSettings(flags, lastKnownGoodPath(flags.StateDir()), readStateFile)
+	if err != nil {
+		return nil, err
+	}
+	s.Path = flags.Config
+	return s, nil
+}
+
+// LoadValidSettings loads the settings like LoadSettings and validates them.
+// If the config is invalid, a daemon falls back to the last known good
+// config, while a one-shot run reports the error instead of applying an old
+// config.
+func LoadValidSettings(flags *Flags) (*Settings, error) {
+	s, err := LoadSettings(flags)
+	if err == nil {
+		err = s.Validate()
+	}
+	if _, ok := err.(ConfigErrors); !ok {
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	lkg, e := LoadLastKnownGood(flags)
+	if e != nil || !(lkg.Daemon || lkg.Detach) || lkg.Validate() != nil {
+		return nil, err
+	}
+	log.Print(err)
+	log.Print("using the last known good config")
+	return lkg, nil
+}
+
+func loadSettings(flags *Flags, config string, read func(string) ([]byte, error)) (s *Settings, err error) {
+	s = newSettings()
+	s.Flags = flags
+	if config != "" {
+		s.Path = config
+		if err = s.readYAML(config, read); err != nil {
+			return nil, err
+		}
+	}
+	flags.Apply(s)
+	if s.SysfsPath == "" {
+		if s.Device, err = FindDevice(); err != nil {
+			return nil, err
+		}
+		s.SysfsPath = s.Device.Path
+	} else {
+		s.Device = ReadDevice(s.SysfsPath)
+	}
+	s.Env = DetectEnvironment(s.Device)
+	if err = s.resolveMatches(); err != nil {
+		return nil, err
+	}
+	s.Values.Apply(flags.Values())
+	if s.ModelDefaults {
+		models, err := LoadModels(s.ModelsDir)
+		if err != nil {
+			return nil, err
+		}
+		if s.Model = MatchModel(models, s.Env.DMI, s.Device.Variant); s.Model != nil {
+			s.Values.Fill(s.Model.Values)
+		}
+	}
+	if s.Unset == UnsetDefault {
+		s.Values.FillDefaults(s.Device.Variant)
+	}
+	return s, nil
+}
+
+// resolveMatches applies the overlays of the matching blocks.
+func (s *Settings) resolveMatches() error {
+	for _, m := range s.Match {
+		if !m.Matches(s.Env) {
+			continue
+		}
+		if err := s.applyOverlay(s.Values, &m.Overlay); err != nil {
+			return ConfigErrors{&ConfigError{File: s.Path, Msg: "match: " + err.Error()}}
+		}
+	}
+	return nil
+}
+
+// applyOverlay applies the profile and the values of the overlay to values.
+func (s *Settings) applyOverlay(values Values, o *Overlay) error {
+	if o == nil {
+		return nil
+	}
+	if o.Profile != "" {
+		p, ok := s.Profiles[o.Profile]
+		if !ok {
+			return fmt.Errorf("unknown profile %q", o.Profile)
+		}
+		values.Apply(p)
+	}
+	values.Apply(o.Values)
+	return nil
+}
+
+// State is the runtime state the daemon adapts the settings to.
+type State struct {
+	Power   PowerState // Power is the state of the power supply.
+	Mouse   bool       // Mouse is true while an external pointing device is present.
+	Profile string     // Profile is the profile chosen at runtime, empty for none.
+	Values  Values     // Values are the values set at runtime, cleared when the config is reloaded.
+	Extra   Extra      // Extra are the extra attributes set at runtime, cleared when the config is reloaded.
+}
+
+// Effective returns the settings with the overlays of the state applied.
+// The command line flags still take precedence.
+func (s *Settings) Effective(state State) *Settings {
+	e := *s
+	e.Values = make(Values)
+	e.Values.Apply(s.Values)
+	switch state.Power {
+	case PowerAC:
+		s.applyOverlay(e.Values, s.OnAC)
+	case PowerBattery:
+		s.applyOverlay(e.Values, s.OnBattery)
+	}
+	if s.ManageExtDev {
+		// ext_dev 1 disables the device on the passthrough port
+		e.Values["ext_dev"] = 1
+		if state.Mouse {
+			e.Values["ext_dev"] = 0
+		}
+	}
+	if state.Mouse {
+		s.applyOverlay(e.Values, s.OnMouse)
+	}
+	s.applyOverlay(e.Values, &Overlay{Profile: state.Profile, Values: state.Values})
+	if s.Flags != nil {
+		e.Values.Apply(s.Flags.Values())
+	}
+	if len(state.Extra) > 0 {
+		e.Extra = make(Extra)
+		for key, value := range s.Extra {
+			e.Extra[key] = value
+		}
+		for key, value := range state.Extra {
+			e.Extra[key] = value
+		}
+	}
+	return &e
+}
+
+// sets checks if the settings set the known or extra attribute.
+func (s *Settings) sets(key string) bool {
+	_, value := s.Values[key]
+	_, extra := s.Extra[key]
+	return value || extra
+}
+
+// Validate checks the settings before they are applied.
+func (s *Settings) Validate() error {
+	if err := s.validateConfig(); err != nil {
+		return err
+	}
+	var errs ConfigErrors
+	if info, err := os.Stat(s.SysfsPath); err != nil {
+		errs = append(errs, &ConfigError{File: s.Path, Msg: err.Error()})
+	} else if !info.IsDir() {
+		errs = append(errs, &ConfigError{File: s.Path, Msg: s.SysfsPath + " is not a directory"})
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// validateConfig checks the settings that do not depend on the device.
+func (s *Settings) validateConfig() error {
+	var errs ConfigErrors
+	if s.Interval <= 0 {
+		errs = append(errs, &ConfigError{File: s.Path, Msg: "interval must be positive"})
+	}
+	if s.Trial < 0 {
+		errs = append(errs, &ConfigError{File: s.Path, Msg: "trial must not be negative"})
+	}
+	if s.Unset != UnsetDefault && s.Unset != UnsetIgnore {
+		errs = append(errs, &ConfigError{File: s.Path,
+			Msg: fmt.Sprintf("unset must be %q or %q, got %q", UnsetDefault, UnsetIgnore, s.Unset)})
+	}
+	if s.ApplyMode != ApplyBestEffort && s.ApplyMode != ApplyAllOrNothing {
+		errs = append(errs, &ConfigError{File: s.Path,
+			Msg: fmt.Sprintf("apply_mode must be %q or %q, got %q", ApplyBestEffort, ApplyAllOrNothing, s.ApplyMode)})
+	}
+	for i, m := range s.Match {
+		if err := m.Validate(); err != nil {
+			errs = append(errs, &ConfigError{File: s.Path, Msg: fmt.Sprintf("match %d: %v", i+1, err)})
+		}
+		if _, ok := s.Profiles[m.Profile]; m.Profile != "" && !ok {
+			errs = append(errs, &ConfigError{File: s.Path, Msg: fmt.Sprintf("match %d: unknown profile %q", i+1, m.Profile)})
+		}
+	}
+	if l := s.Libinput; l != nil {
+		if err := l.Validate(); err != nil {
+			errs = append(errs, &ConfigError{File: s.Path, Msg: "libinput: " + err.Error()})
+		}
+		// udev writes the hwdb property to the device on every add
+		if v, ok := s.Values["sensitivity"]; l.Sensitivity != 0 && (!ok || v != l.Sensitivity) {
+			errs = append(errs, &ConfigError{File: s.Path,
+				Msg: fmt.Sprintf("libinput: sensitivity %d must be the same as values.sensitivity", l.Sensitivity)})
+		}
+	}
+	for name, o := range map[string]*Overlay{"on_ac": s.OnAC, "on_battery": s.OnBattery, "on_mouse": s.OnMouse} {
+		if o == nil || o.Profile == "" {
+			continue
+		}
+		if _, ok := s.Profiles[o.Profile]; !ok {
+			errs = append(errs, &ConfigError{File: s.Path, Msg: fmt.Sprintf("%s: unknown profile %q", name, o.Profile)})
+		}
+	}
+	if s.User != "" && len(s.Extra) > 0 {
+		errs = append(errs, &ConfigError{File: s.Path,
+			Msg: "extra cannot be used with user, as the helper writes only the known attributes"})
+	}
+	for _, key := range s.Extra.Keys() {
+		switch value := s.Extra[key]; {
+		case LookupAttribute(key) != nil:
+			errs = append(errs, &ConfigError{File: s.Path,
+				Msg: fmt.Sprintf("extra: %q is a known attribute, set it in values", key)})
+		case !extraNamePattern.MatchString(key):
+			errs = append(errs, &ConfigError{File: s.Path,
+				Msg: fmt.Sprintf("extra: %q is not a valid attribute name", key)})
+		case value == "" || strings.ContainsAny(value, "\n\r"):
+			errs = append(errs, &ConfigError{File: s.Path,
+				Msg: fmt.Sprintf("extra: %q is not a valid value for %s", value, key)})
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// SaveLastKnownGood persists the config file the settings were loaded from.
+func (s *Settings) SaveLastKnownGood() error {
+	if s.source == nil {
+		return nil
+	}
+	if err := os.MkdirAll(s.StateDir, 0755); err != nil {
+		return err
+	}
+	path := lastKnownGoodPath(s.StateDir)
+	if err := ioutil.WriteFile(path+".tmp", s.source, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func lastKnownGoodPath(stateDir string) string {
+	return filepath.Join(stateDir, "last-good.yml")
+}
+
+// SetDefaults resets the settings to the defaults of the variant.
+func (v Values) SetDefaults(variant Variant) {
+	for key := range v {
+		delete(v, key)
+	}
+	v.FillDefaults(variant)
+}
+
+// FillDefaults sets the values that are not set to the defaults of the variant.
+func (v Values) FillDefaults(variant Variant) {
+	v.Fill(variant.Defaults())
+}
+
+// Apply sets the values to the ones of other.
+func (v Values) Apply(other Values) {
+	for key, value := range other {
+		v[key] = value
+	}
+}
+
+// Fill sets the values that are not set to the ones of other.
+func (v Values) Fill(other Values) {
+	for key, value := range other {
+		if _, ok := v[key]; !ok {
+			v[key] = value
+		}
+	}
+}
+
+// Get gets the value of the key or an empty string if it is not set.
+func (v Values) Get(key string) string {
+	a := LookupAttribute(key)
+	if a == nil {
+		return ""
+	}
+	if value, ok := v[a.Name]; ok {
+		return a.Format(value)
+	}
+	return ""
+}
+
+// Set parses and sets the value of the key.
+func (v Values) Set(key, value string) error {
+	a := LookupAttribute(key)
+	if a == nil {
+		return fmt.Errorf("unknown attribute %q", key)
+	}
+	x, err := a.Parse(value)
+	if err != nil {
+		return err
+	}
+	v[a.Name] = x
+	return nil
+}
+
+// MarshalYAML encodes the values in the order of the attributes, booleans
+// as true or false, so they can be decoded again.
+func (v Values) MarshalYAML() (interface{}, error) {
+	var m yaml.MapSlice
+	for _, a := range Attributes {
+		if x, ok := v[a.Name]; ok {
+			if a.Type == TypeBool {
+				m = append(m, yaml.MapItem{Key: a.Name, Value: x != 0})
+			} else {
+				m = append(m, yaml.MapItem{Key: a.Name, Value: x})
+			}
+		}
+	}
+	return m, nil
+}
+
+// UnmarshalYAML decodes the values section of the config file.
+func (v *Values) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	s := reflect.New(valuesType)
+	if err := unmarshal(s.Interface()); err != nil {
+		if e, ok := err.(*yaml.TypeError); ok {
+			for i := range e.Errors {
+				e.Errors[i] = renameValuesType(e.Errors[i])
+			}
+		}
+		return err
+	}
+	values, err := decodeValues(s.Elem())
+	if err != nil {
+		return err
+	}
+	*v = values
+	return nil
 }
 
 // ReadYAML reads a YAML file into the settings.
 func (s *Settings) ReadYAML(path string) error {
-	bytes, err := ioutil.ReadFile(path)
+	return s.readYAML(path, ioutil.ReadFile)
+}
+
+func (s *Settings) readYAML(path string, read func(string) ([]byte, error)) error {
+	bytes, err := read(path)
 	if err != nil {
 		return err
 	}
-	return yaml.Unmarshal(bytes, s)
+	if err = yaml.UnmarshalStrict(bytes, s); err != nil {
+		return newConfigErrors(path, bytes, err)
+	}
+	s.source = bytes
+	return nil
+}
+
+// Keys returns the names of the extra attributes in order.
+func (e Extra) Keys() []string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
-// Get gets the value of the key.
+// Get gets the value of the key or an empty string if it is not set.
 func (s *Settings) Get(k string) string {
-	var value string
-	found := errors.New("found")
-	s.ForEach(func(k2, v string) error {
-		if k == k2 {
-			value = v
-			return found
-		}
-		return nil
-	})
-	return value
+	if v, ok := s.Extra[k]; ok {
+		return v
+	}
+	return s.Values.Get(k)
+}
+
+// Set parses and sets the value of the key.
+func (s *Settings) Set(k, v string) error {
+	return s.Values.Set(k, v)
 }
 
 // Keys gets the keys of the settings.
 func (s *Settings) Keys() []string {
-	t := reflect.TypeOf(*s.Values)
-	keys := make([]string, t.NumField())
-	for i := 0; i < t.NumField(); i++ {
-		keys[i] = t.Field(i).Tag.Get("trackpoint")
-	}
-	return keys
+	return AttributeNames()
 }
 
-// ForEach iterates over the settings.
-func (s *Settings) ForEach(fn func(key, value string) error) (err error) {
-	v := reflect.ValueOf(*s.Values)
-	t := v.Type()
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-		tag := f.Tag.Get("trackpoint")
-		switch f.Type.Kind() {
-		case reflect.Uint8:
-			err = fn(tag, strconv.FormatUint(v.Field(i).Uint(), 10))
-		case reflect.Bool:
-			if v.Field(i).Bool() {
-				err = fn(tag, "1")
-			} else {
-				err = fn(tag, "0")
+// DeviceKeys returns the names of the known and of the extra attributes.
+func (s *Settings) DeviceKeys() []string {
+	return append(AttributeNames(), s.Extra.Keys()...)
+}
+
+// ForEach iterates over the values that are set, followed by the extra attributes.
+func (s *Settings) ForEach(fn func(key, value string) error) error {
+	for _, a := range Attributes {
+		if v, ok := s.Values[a.Name]; ok {
+			if err := fn(a.Name, a.Format(v)); err != nil {
+				return err
 			}
 		}
-		if err != nil {
-			return
+	}
+	for _, key := range s.Extra.Keys() {
+		if err := fn(key, s.Extra[key]); err != nil {
+			return err
 		}
 	}
-	return
+	return nil
 }
 
-// ToStringMap convert the settings to a map.
+// ToStringMap convert the values that are set to a map.
 func (s *Settings) ToStringMap() map[string]string {
 	m := make(map[string]string)
 	err := s.ForEach(func(key string, value string) error {
@@ -2,7 +2,11 @@ package main
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 var defaults = map[string]string{
@@ -39,49 +43,24 @@ var keys = []string{
 	"ext_dev",
 }
 
-func checkDefaults(s *Values, t *testing.T) {
-	if s.DragHysteresis != DefaultDragHysteresis {
-		t.Fatalf("Expected %v, got %v", DefaultDragHysteresis, s.DragHysteresis)
+func checkDefaults(s Values, t *testing.T) {
+	if len(s) != len(defaults) {
+		t.Fatalf("Expected %v values, got %v", len(defaults), len(s))
 	}
-	if s.Threshold != DefaultThreshold {
-		t.Fatalf("Expected %v, got %v", DefaultThreshold, s.Threshold)
-	}
-	if s.UpThreshold != DefaultUpThreshold {
-		t.Fatalf("Expected %v, got %v", DefaultUpThreshold, s.UpThreshold)
-	}
-	if s.ZTime != DefaultZTime {
-		t.Fatalf("Expected %v, got %v", DefaultZTime, s.ZTime)
-	}
-	if s.Sensitivity != DefaultSensitivity {
-		t.Fatalf("Expected %v, got %v", DefaultSensitivity, s.Sensitivity)
-	}
-	if s.Inertia != DefaultInertia {
-		t.Fatalf("Expected %v, got %v", DefaultInertia, s.Inertia)
-	}
-	if s.Speed != DefaultSpeed {
-		t.Fatalf("Expected %v, got %v", DefaultSpeed, s.Speed)
-	}
-	if s.Reach != DefaultReach {
-		t.Fatalf("Expected %v, got %v", DefaultReach, s.Reach)
-	}
-	if s.MinDrag != DefaultMinDrag {
-		t.Fatalf("Expected %v, got %v", DefaultMinDrag, s.MinDrag)
-	}
-	if s.Jenks != DefaultJenks {
-		t.Fatalf("Expected %v, got %v", DefaultJenks, s.Jenks)
-	}
-	if s.DriftTime != DefaultDriftTime {
-		t.Fatalf("Expected %v, got %v", DefaultDriftTime, s.DriftTime)
-	}
-	if s.PressToSelect != DefaultPressToSelect {
-		t.Fatalf("Expected %v, got %v", DefaultPressToSelect, s.PressToSelect)
-	}
-	if s.Skipback != DefaultSkipback {
-		t.Fatalf("Expected %v, got %v", DefaultSkipback, s.Skipback)
+	for key, value := range defaults {
+		if actual := s.Get(key); actual != value {
+			t.Fatalf("Expected %v for %v, got %v", value, key, actual)
+		}
 	}
-	if s.ExternalDevice != DefaultExternalDevice {
-		t.Fatalf("Expected %v, got %v", DefaultExternalDevice, s.ExternalDevice)
+}
+
+func clearValues(s Values) {
+	for _, key := range keys {
+		s[key] = 0
 	}
+	s["press_to_select"] = 1
+	s["skipback"] = 1
+	s["ext_dev"] = 1
 }
 
 func TestSettingsNew(t *testing.T) {
@@ -90,54 +69,47 @@ func TestSettingsNew(t *testing.T) {
 }
 
 func TestSettings_SetDefaults(t *testing.T) {
-	s2 := NewSettings()
-	s := s2.Values
-	s.DragHysteresis = 0
-	s.Threshold = 0
-	s.UpThreshold = 0
-	s.ZTime = 0
-	s.Sensitivity = 0
-	s.Inertia = 0
-	s.Speed = 0
-	s.Reach = 0
-	s.MinDrag = 0
-	s.Jenks = 0
-	s.DriftTime = 0
-	s.PressToSelect = true
-	s.Skipback = true
-	s.ExternalDevice = true
-	s.SetDefaults()
+	s := NewSettings().Values
+	clearValues(s)
+	s.SetDefaults(VariantIBM)
 	checkDefaults(s, t)
 }
 
 func TestSettings_ReadYAML(t *testing.T) {
-	s2 := NewSettings()
-	s := s2.Values
-	s.DragHysteresis = 0
-	s.Threshold = 0
-	s.UpThreshold = 0
-	s.ZTime = 0
-	s.Sensitivity = 0
-	s.Inertia = 0
-	s.Speed = 0
-	s.Reach = 0
-	s.MinDrag = 0
-	s.Jenks = 0
-	s.DriftTime = 0
-	s.PressToSelect = true
-	s.Skipback = true
-	s.ExternalDevice = true
-	err := s2.ReadYAML("./trackpoint.yml")
+	s := NewSettings()
+	clearValues(s.Values)
+	err := s.ReadYAML("./trackpoint.yml")
 	if err != nil {
 		t.Fatal(err)
 	}
-	checkDefaults(s, t)
+	checkDefaults(s.Values, t)
 
-	if s2.ReadYAML("./trackpoint-asdf.yml") == nil {
+	if s.ReadYAML("./trackpoint-asdf.yml") == nil {
 		t.Fatal("expected error")
 	}
 }
 
+func TestSettings_Set(t *testing.T) {
+	s := NewSettings()
+	if err := s.Set("drifttime", "7"); err != nil {
+		t.Fatal(err)
+	}
+	if actual := s.Get("drift_time"); actual != "7" {
+		t.Fatalf("expected %v, got %v", "7", actual)
+	}
+	if err := s.Set("pts", "true"); err != nil {
+		t.Fatal(err)
+	}
+	if actual := s.Get("press_to_select"); actual != "1" {
+		t.Fatalf("expected %v, got %v", "1", actual)
+	}
+	for _, kv := range [][2]string{{"speed", "256"}, {"speed", "fast"}, {"skipback", "2"}, {"bogus", "1"}} {
+		if err := s.Set(kv[0], kv[1]); err == nil {
+			t.Fatalf("expected error for %v=%v", kv[0], kv[1])
+		}
+	}
+}
+
 func TestSettings_ForEach(t *testing.T) {
 	s := NewSettings()
 	e := errors.New("dummy error")
@@ -173,7 +145,7 @@ func TestSettings_ForEach(t *testing.T) {
 func TestSettings_ToStringMap(t *testing.T) {
 
 	s := NewSettings()
-	s.Values.PressToSelect = true
+	s.Values["press_to_select"] = 1
 	actual := s.ToStringMap()
 	if len(defaults) != len(actual) {
 		t.Fatalf("expected length of %v, got %v", len(defaults), len(actual))
@@ -219,3 +191,293 @@ func TestSettings_Get(t *testing.T) {
 		t.Fatalf("expected %v, got %v", nil, actual)
 	}
 }
+
+func TestLoadSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trackpoint.yml")
+
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("sysfs: /dev/null\ninterval: 10s\nvalues:\n  speed: 1\n  sensitivity: 2\n")
+	flags, err := ParseFlags([]string{"trackpoint", "--config", path, "--sensitivity", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSettings(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Values["speed"] != 1 {
+		t.Fatalf("expected %v, got %v", 1, s.Values["speed"])
+	}
+	if s.Values["sensitivity"] != 3 {
+		t.Fatalf("expected %v, got %v", 3, s.Values["sensitivity"])
+	}
+	if s.Interval != 10*time.Second {
+		t.Fatalf("expected %v, got %v", 10*time.Second, s.Interval)
+	}
+
+	write("sysfs: /dev/zero\nvalues:\n  sensitivity: 2\n")
+	s, err = LoadSettings(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Get("speed") != defaults["speed"] {
+		t.Fatalf("expected %v, got %v", defaults["speed"], s.Get("speed"))
+	}
+	if s.Values["sensitivity"] != 3 {
+		t.Fatalf("expected %v, got %v", 3, s.Values["sensitivity"])
+	}
+	if s.Interval != DefaultInterval {
+		t.Fatalf("expected %v, got %v", DefaultInterval, s.Interval)
+	}
+	if s.SysfsPath != "/dev/zero" {
+		t.Fatalf("expected %v, got %v", "/dev/zero", s.SysfsPath)
+	}
+}
+
+func TestLoadLastKnownGood(t *testing.T) {
+	path, cleanup := writeConfig(t, "sysfs: /dev/null\nvalues:\n  speed: 1\n")
+	defer cleanup()
+	stateDir := filepath.Join(filepath.Dir(path), "state")
+
+	flags, err := ParseFlags([]string{"trackpoint", "--config", path, "--state-dir", stateDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSettings(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SaveLastKnownGood(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("values: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadSettings(flags); err == nil {
+		t.Fatal("expected error")
+	}
+	s, err = LoadLastKnownGood(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Values["speed"] != 1 {
+		t.Fatalf("expected %v, got %v", 1, s.Values["speed"])
+	}
+	if s.Path != path {
+		t.Fatalf("expected %v, got %v", path, s.Path)
+	}
+}
+
+func TestLoadValidSettings(t *testing.T) {
+	path, cleanup := writeConfig(t, "")
+	defer cleanup()
+	dir := filepath.Dir(path)
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte("sysfs: "+dir+"\n"+content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flags, err := ParseFlags([]string{"trackpoint", "--config", path, "--state-dir", filepath.Join(dir, "state")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("daemon: true\ninterval: 0s\n")
+	if _, err = LoadValidSettings(flags); err == nil {
+		t.Fatal("expected error without a last known good config")
+	}
+
+	write("daemon: true\ninterval: 1s\n")
+	s, err := LoadValidSettings(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SaveLastKnownGood(); err != nil {
+		t.Fatal(err)
+	}
+	write("daemon: true\ninterval: 0s\n")
+	if s, err = LoadValidSettings(flags); err != nil {
+		t.Fatal(err)
+	}
+	if s.Interval != time.Second {
+		t.Fatalf("expected %v, got %v", time.Second, s.Interval)
+	}
+
+	// a one-shot run does not apply an old config
+	write("unset: none\n")
+	if err = ioutil.WriteFile(lastKnownGoodPath(flags.StateDir()), []byte("sysfs: "+dir+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadValidSettings(flags); err == nil {
+		t.Fatal("expected error for a one-shot run")
+	}
+}
+
+func TestLoadSettingsUnset(t *testing.T) {
+	path, cleanup := writeConfig(t, "sysfs: /dev/null\nunset: ignore\nvalues:\n  sensitivity: 200\n")
+	defer cleanup()
+
+	flags, err := ParseFlags([]string{"trackpoint", "--config", path, "--pts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSettings(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"sensitivity": "200", "press_to_select": "1"}
+	actual := s.ToStringMap()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	for key, value := range expected {
+		if actual[key] != value {
+			t.Fatalf("expected %v, got %v", expected, actual)
+		}
+	}
+	if s.Get("speed") != "" {
+		t.Fatalf("expected speed to be unset, got %v", s.Get("speed"))
+	}
+
+	flags, err = ParseFlags([]string{"trackpoint", "--config", path, "--unset", "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err = LoadSettings(flags); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.ToStringMap()) != len(defaults) {
+		t.Fatalf("expected all values to be set, got %v", s.ToStringMap())
+	}
+	if s.Get("sensitivity") != "200" {
+		t.Fatalf("expected %v, got %v", "200", s.Get("sensitivity"))
+	}
+}
+
+func TestSettings_Extra(t *testing.T) {
+	path, cleanup := writeConfig(t, "extra:\n  new_thing: 3\n")
+	defer cleanup()
+	s := NewSettings()
+	if err := s.ReadYAML(path); err != nil {
+		t.Fatal(err)
+	}
+	if s.Get("new_thing") != "3" {
+		t.Fatalf("expected %v, got %v", "3", s.Get("new_thing"))
+	}
+	if m := s.ToStringMap(); len(m) != len(defaults)+1 || m["new_thing"] != "3" {
+		t.Fatalf("expected new_thing in %v", m)
+	}
+
+	for _, extra := range []Extra{{"speed": "1"}, {"../speed": "1"}, {"new_thing": "1\n2"}} {
+		s.Extra = extra
+		if err := s.validateConfig(); err == nil {
+			t.Fatalf("expected error for %v", extra)
+		}
+	}
+	// the helper of the user does not write extra attributes
+	s.Extra, s.User = Extra{"new_thing": "3"}, "trackpoint"
+	if err := s.validateConfig(); err == nil {
+		t.Fatal("expected error for extra with user")
+	}
+}
+
+func TestLoadSettingsVariantDefaults(t *testing.T) {
+	base, cleanup := fakeSysfs(t, "TPPS/2 ALPS TrackPoint")
+	defer cleanup()
+
+	flags, err := ParseFlags([]string{"trackpoint", "--sysfs", filepath.Join(base, "serio1/serio2"), "--speed", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSettings(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Device.Variant != VariantALPS {
+		t.Fatalf("expected %v, got %v", VariantALPS, s.Device.Variant)
+	}
+	expected := map[string]string{"sensitivity": "128", "press_to_select": "0", "speed": "3"}
+	if actual := s.ToStringMap(); len(actual) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestSettings_Effective(t *testing.T) {
+	path, cleanup := writeConfig(t, `sysfs: /dev/null
+values:
+  speed: 1
+  sensitivity: 1
+profiles:
+  precise:
+    sensitivity: 50
+on_ac:
+  values:
+    speed: 2
+on_battery:
+  profile: precise
+  values:
+    speed: 3
+    inertia: 4
+`)
+	defer cleanup()
+
+	flags, err := ParseFlags([]string{"trackpoint", "--config", path, "--inertia", "9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSettings(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		power    PowerState
+		expected map[string]string
+	}{
+		{PowerUnknown, map[string]string{"speed": "1", "sensitivity": "1", "inertia": "9"}},
+		{PowerAC, map[string]string{"speed": "2", "sensitivity": "1", "inertia": "9"}},
+		{PowerBattery, map[string]string{"speed": "3", "sensitivity": "50", "inertia": "9"}},
+	}
+	for _, test := range tests {
+		e := s.Effective(State{Power: test.power})
+		for key, value := range test.expected {
+			if actual := e.Get(key); actual != value {
+				t.Fatalf("expected %v for %v on %v, got %v", value, key, test.power, actual)
+			}
+		}
+	}
+	if s.Get("speed") != "1" {
+		t.Fatalf("expected %v, got %v", "1", s.Get("speed"))
+	}
+
+	s.OnBattery.Profile = "fast"
+	if err = s.validateConfig(); err == nil {
+		t.Fatal("expected error for unknown profile")
+	}
+}
+
+func TestSettings_EffectiveMouse(t *testing.T) {
+	s := NewSettings()
+	s.Profiles = Profiles{"mouse": {"sensitivity": 50}}
+	s.OnMouse = &Overlay{Profile: "mouse"}
+	s.ManageExtDev = true
+
+	e := s.Effective(State{})
+	if e.Get("ext_dev") != "1" || e.Get("sensitivity") != s.Get("sensitivity") {
+		t.Fatalf("expected ext_dev 1 and sensitivity %v, got %v and %v", s.Get("sensitivity"), e.Get("ext_dev"), e.Get("sensitivity"))
+	}
+	e = s.Effective(State{Mouse: true})
+	if e.Get("ext_dev") != "0" || e.Get("sensitivity") != "50" {
+		t.Fatalf("expected ext_dev 0 and sensitivity 50, got %v and %v", e.Get("ext_dev"), e.Get("sensitivity"))
+	}
+}
@@ -21,34 +21,79 @@ var (
 	ErrDeviceDirNotFound = errors.New("device directory not found")
 )
 
+// Device is a TrackPoint device in the SYSFS.
+type Device struct {
+	Path    string  // Path is the directory of the device holding the attributes.
+	Name    string  // Name is the name of the input device, e.g. "TPPS/2 IBM TrackPoint".
+	Phys    string  // Phys is the physical path of the input device.
+	Variant Variant // Variant is the variant parsed from the name.
+}
+
 // GetDeviceDirectory get the device directory of the TrackPoint in the SYS FS.
-func GetDeviceDirectory() (result string, err error) {
+func GetDeviceDirectory() (string, error) {
+	d, err := FindDevice()
+	if err != nil {
+		return "", err
+	}
+	return d.Path, nil
+}
+
+// FindDevice searches the TrackPoint in the SYS FS.
+func FindDevice() (result *Device, err error) {
 	err = RetryWait(1*time.Second, func(attempt uint) (bool, error) {
-		errFileFound := errors.New("file found")
-		err = filepath.Walk(SysfsBaseDir,
-			func(path string, info os.FileInfo, err error) error {
+		result, err = findDevice(SysfsBaseDir)
+		return attempt < 10, err
+	})
+	return result, err
+}
+
+func findDevice(base string) (*Device, error) {
+	var result *Device
+	errFileFound := errors.New("file found")
+	err := filepath.Walk(base,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && info.Name() == "name" {
+				bytes, err := ioutil.ReadFile(path)
 				if err != nil {
 					return err
 				}
-				if !info.IsDir() && info.Name() == "name" {
-					bytes, err := ioutil.ReadFile(path)
-					if err != nil {
-						return err
-					}
-					if strings.Contains(string(bytes), TrackPointName) {
-						result = path
-						return errFileFound
-					}
+				if strings.Contains(string(bytes), TrackPointName) {
+					result = newDevice(filepath.Dir(filepath.Dir(filepath.Dir(path))), filepath.Dir(path))
+					return errFileFound
 				}
-				return nil
-			})
-		if err == errFileFound {
-			err = nil
-			result = filepath.Dir(filepath.Dir(filepath.Dir(result)))
-		} else if err == nil {
-			err = ErrDeviceDirNotFound
-		}
-		return attempt < 10, err
-	})
-	return result, err
+			}
+			return nil
+		})
+	if err == errFileFound {
+		return result, nil
+	} else if err == nil {
+		err = ErrDeviceDirNotFound
+	}
+	return nil, err
+}
+
+// ReadDevice reads the device with the given directory. The name, phys and
+// variant stay empty if the directory has no input device.
+func ReadDevice(path string) *Device {
+	inputs, _ := filepath.Glob(filepath.Join(path, "input", "input*"))
+	if len(inputs) == 0 {
+		return &Device{Path: path, Variant: VariantUnknown}
+	}
+	return newDevice(path, inputs[0])
+}
+
+func newDevice(path, input string) *Device {
+	d := &Device{Path: path}
+	d.Name = readTrimmed(filepath.Join(input, "name"))
+	d.Phys = readTrimmed(filepath.Join(input, "phys"))
+	d.Variant = ParseVariant(d.Name)
+	return d
+}
+
+func readTrimmed(path string) string {
+	bytes, _ := ioutil.ReadFile(path)
+	return strings.TrimSpace(string(bytes))
 }
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -14,3 +17,52 @@ func TestGetDeviceDirectory(t *testing.T) {
 		t.Fatalf("expected %v, got %v", expected, path)
 	}
 }
+
+// fakeSysfs creates a i8042 tree with a TrackPoint of the given name.
+func fakeSysfs(t *testing.T, name string) (base string, cleanup func()) {
+	base, err := ioutil.TempDir("", "trackpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"serio1/input/input5/name":        "SynPS/2 Synaptics TouchPad\n",
+		"serio1/serio2/input/input7/name": name + "\n",
+		"serio1/serio2/input/input7/phys": "isa0060/serio1/serio2/input0\n",
+		"serio1/serio2/sensitivity":       "128\n",
+	}
+	for path, content := range files {
+		path = filepath.Join(base, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base, func() { os.RemoveAll(base) }
+}
+
+func TestFindDevice(t *testing.T) {
+	base, cleanup := fakeSysfs(t, "TPPS/2 Elan TrackPoint")
+	defer cleanup()
+
+	d, err := findDevice(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(base, "serio1/serio2"); d.Path != expected {
+		t.Fatalf("expected %v, got %v", expected, d.Path)
+	}
+	if d.Variant != VariantElan {
+		t.Fatalf("expected %v, got %v", VariantElan, d.Variant)
+	}
+	if d.Phys != "isa0060/serio1/serio2/input0" {
+		t.Fatalf("expected %v, got %v", "isa0060/serio1/serio2/input0", d.Phys)
+	}
+	if r := ReadDevice(d.Path); *r != *d {
+		t.Fatalf("expected %v, got %v", d, r)
+	}
+	if _, err = findDevice(filepath.Join(base, "serio1/input")); err != ErrDeviceDirNotFound {
+		t.Fatalf("expected %v, got %v", ErrDeviceDirNotFound, err)
+	}
+}
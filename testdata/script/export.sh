@@ -0,0 +1,34 @@
+#!/bin/sh
+# TrackPoint settings exported by trackpoint from /etc/trackpoint.yml.
+# Waits for the TrackPoint like trackpoint does and writes the values.
+set -u
+
+base=/sys/devices/platform/i8042
+device=
+attempt=1
+while :; do
+	for name in $(find "$base" -type f -name name 2>/dev/null); do
+		if grep -q TrackPoint "$name"; then
+			device=$(dirname "$(dirname "$(dirname "$name")")")
+			break 2
+		fi
+	done
+	if [ "$attempt" -ge 10 ]; then
+		echo "device directory not found" >&2
+		exit 1
+	fi
+	attempt=$((attempt + 1))
+	sleep 1
+done
+
+write() {
+	if [ -f "$device/$1" ]; then
+		printf '%s' "$2" > "$device/$1" || echo "$1: could not write $2" >&2
+	else
+		echo "$1: not supported by the device, skipping" >&2
+	fi
+}
+
+write sensitivity 200
+write press_to_select 1
+write new_thing 3
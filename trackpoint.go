@@ -1,115 +1,207 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"log"
 	"os"
 	"time"
 )
 
-// ParseFlags parses the supplied arguments to the settings.
-func ParseFlags(args []string, settings *Settings) (err error) {
+// Flags are the options given on the command line.
+type Flags struct {
+	Config string                 // Config is the path to the config file.
+	Set    map[string]interface{} // Set are the explicitly given flags by name.
+	Args   []string               // Args are the arguments after the flags.
+}
+
+// ParseFlags parses the supplied arguments.
+func ParseFlags(args []string) (flags *Flags, err error) {
 	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+	flags = &Flags{Set: make(map[string]interface{})}
 
-	settings.SysfsPath, err = GetDeviceDirectory()
-	if err != nil {
-		return err
+	fs.StringVar(&flags.Config, "config", "", "The path to the config file")
+	fs.StringVar(&flags.Config, "c", "", "The path to the config file (shorthand)")
+	fs.Duration("interval", DefaultInterval, "The interval at which the daemon executes.")
+	fs.String("sysfs", "", "The path to the SYSFS device. (default is to search for it)")
+	fs.String("state-dir", DefaultStateDir, "The directory for persistent state.")
+	fs.String("run-dir", DefaultRunDir, "The directory for runtime files like the control socket.")
+	fs.Bool("model-defaults", false, "Use the recommended values of the model database for values that are not set.")
+	fs.String("models-dir", DefaultModelsDir, "The directory of user defined models.")
+	fs.Bool("restore-on-exit", false, "Restore the values the device had before the daemon started when it stops.")
+	fs.Bool("manage-ext-dev", false, "Enable the passthrough port only while an external pointing device is present.")
+	fs.String("unset", UnsetDefault, "The policy for values that are not set: \"default\" enforces the defaults, \"ignore\" leaves them untouched.")
+	fs.String("user", "", "The user the daemon drops its privileges to, writing through a privileged helper.")
+	fs.Bool("sandbox", false, "Restrict the daemon to the files and system calls it needs.")
+	fs.String("apply-mode", ApplyBestEffort, "How values are written: \"best-effort\" keeps the values that could be written, \"all-or-nothing\" restores the previous values if any fails.")
+
+	for _, a := range Attributes {
+		for _, name := range append([]string{a.Name}, a.Aliases...) {
+			usage := a.Description
+			if name != a.Name {
+				usage = fmt.Sprintf("Alias of --%s.", a.Name)
+			}
+			fs.Var(&attributeFlag{attr: a, value: a.Default}, name, usage)
+		}
 	}
 
-	var config string
-	var interval string
+	fs.Bool("wait", false, "Wait for another instance writing to the device instead of failing.")
+	fs.Bool("fail-if-locked", false, "Fail if another instance writes to the device. (default)")
 
-	fs.StringVar(&config, "config", "", "The path to the config file")
-	fs.StringVar(&config, "c", "", "The path to the config file (shorthand)")
-	fs.StringVar(&interval, "interval", "30s", "The interval at which the daemon executes.")
-	fs.StringVar(&settings.SysfsPath, "sysfs", settings.SysfsPath, "The path to the SYSFS device.")
+	fs.Bool("daemon", false, "Run as a daemon")
+	fs.Bool("d", false, "Run as a daemon (shorthand)")
+	fs.Bool("detach", false, "Run the daemon in the background, e.g. for init systems other than systemd.")
+	fs.String("log-file", "", "The file the log goes to, reopened on SIGUSR2. (default stderr, or \""+DefaultLogFile+"\" if detached)")
 
-	fs.Uint("draghys", DefaultDragHysteresis, "Drag Hysteresis (how hard it is to drag with Z-axis pressed).")
-	fs.Uint("thresh", DefaultThreshold, "Minimum value for a Z-axis press.")
-	fs.Uint("upthresh", DefaultUpThreshold, "Used to generate a 'click' on Z-axis.")
-	fs.Uint("ztime", DefaultZTime, "How sharp of a press.")
-	fs.Uint("reach", DefaultReach, "Backup for Z-axis press.")
-	fs.Uint("jenks", DefaultJenks, "Minimum curvature for double click.")
-	fs.Uint("drifttime", DefaultDriftTime, "How long a 'hands off' condition must last for drift correction to occur.")
-	fs.Uint("speed", DefaultSpeed, "Speed of TP Cursor.")
-	fs.Uint("sensitivity", DefaultSensitivity, "Sensitivity.")
-	fs.Uint("inertia", DefaultInertia, "Negative Inertia.")
-	fs.Uint("mindrag", DefaultMinDrag, "Minimum amount of force needed to trigger dragging.")
-	fs.Bool("pts", DefaultPressToSelect, "If press-to-select should be active.")
-	fs.Bool("skipback", DefaultSkipback, "Suppress movement after drag release.")
-	fs.Bool("extdev", DefaultExternalDevice, "Disable external device.")
+	if err = fs.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+	flags.Args = fs.Args()
+	if isSet(fs, "wait") && isSet(fs, "fail-if-locked") {
+		return nil, errors.New("--wait and --fail-if-locked exclude each other")
+	}
 
-	fs.BoolVar(&settings.Daemon, "daemon", false, "Run as a daemon")
-	fs.BoolVar(&settings.Daemon, "d", false, "Run as a daemon (shorthand)")
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "config", "c":
+		case "d":
+			flags.Set["daemon"] = f.Value.(flag.Getter).Get()
+		default:
+			if a := LookupAttribute(f.Name); a != nil {
+				flags.Set[a.Name] = f.Value.(flag.Getter).Get()
+				return
+			}
+			flags.Set[f.Name] = f.Value.(flag.Getter).Get()
+		}
+	})
+	return
+}
 
-	fs.Parse(args[1:])
+func isSet(fs *flag.FlagSet, name string) (set bool) {
+	fs.Visit(func(f *flag.Flag) {
+		set = set || (f.Name == name && f.Value.String() == "true")
+	})
+	return
+}
 
-	settings.Interval, err = time.ParseDuration(interval)
+// Wait returns whether a one-shot run waits for the lock of the device.
+func (f *Flags) Wait() bool {
+	wait, _ := f.Set["wait"].(bool)
+	return wait
+}
 
-	if err != nil {
-		return err
+// StateDir returns the state directory given on the command line or the default.
+func (f *Flags) StateDir() string {
+	if dir, ok := f.Set["state-dir"]; ok {
+		return dir.(string)
 	}
+	return DefaultStateDir
+}
 
-	if config != "" {
-		settings.Path = config
-		err = settings.ReadYAML(config)
-		if err != nil {
-			return
+// Values returns the explicitly given attribute values.
+func (f *Flags) Values() Values {
+	values := make(Values)
+	for name, v := range f.Set {
+		if a := LookupAttribute(name); a != nil {
+			values[a.Name] = v.(uint8)
 		}
 	}
+	return values
+}
 
-	fs.Visit(func(f *flag.Flag) {
-		v := f.Value.(flag.Getter).Get()
-		switch f.Name {
-		case "draghys":
-			settings.Values.DragHysteresis = uint8(v.(uint))
-		case "thresh":
-			settings.Values.Threshold = uint8(v.(uint))
-		case "upthresh":
-			settings.Values.UpThreshold = uint8(v.(uint))
-		case "ztime":
-			settings.Values.ZTime = uint8(v.(uint))
-		case "reach":
-			settings.Values.Reach = uint8(v.(uint))
-		case "jenks":
-			settings.Values.Jenks = uint8(v.(uint))
-		case "drifttime":
-			settings.Values.DriftTime = uint8(v.(uint))
-		case "speed":
-			settings.Values.Speed = uint8(v.(uint))
-		case "sensitivity":
-			settings.Values.Sensitivity = uint8(v.(uint))
-		case "inertia":
-			settings.Values.Inertia = uint8(v.(uint))
-		case "mindrag":
-			settings.Values.MinDrag = uint8(v.(uint))
-		case "pts":
-			settings.Values.PressToSelect = v.(bool)
-		case "skipback":
-			settings.Values.Skipback = v.(bool)
-		case "extdev":
-			settings.Values.ExternalDevice = v.(bool)
+// Apply applies the explicitly given flags to the settings.
+func (f *Flags) Apply(settings *Settings) {
+	for name, v := range f.Set {
+		switch name {
+		case "interval":
+			settings.Interval = v.(time.Duration)
+		case "sysfs":
+			settings.SysfsPath = v.(string)
+		case "state-dir":
+			settings.StateDir = v.(string)
+		case "run-dir":
+			settings.RunDir = v.(string)
+		case "daemon":
+			settings.Daemon = v.(bool)
+		case "detach":
+			settings.Detach = v.(bool)
+		case "log-file":
+			settings.LogFile = v.(string)
+		case "unset":
+			settings.Unset = v.(string)
+		case "apply-mode":
+			settings.ApplyMode = v.(string)
+		case "model-defaults":
+			settings.ModelDefaults = v.(bool)
+		case "models-dir":
+			settings.ModelsDir = v.(string)
+		case "restore-on-exit":
+			settings.RestoreOnExit = v.(bool)
+		case "manage-ext-dev":
+			settings.ManageExtDev = v.(bool)
+		case "user":
+			settings.User = v.(string)
+		case "sandbox":
+			settings.Sandbox = v.(bool)
+		default:
+			if a := LookupAttribute(name); a != nil {
+				settings.Values[a.Name] = v.(uint8)
+			}
 		}
-	})
-	return
+	}
 }
 
 func main() {
-	var err error
-	settings := NewSettings()
+	if len(os.Args) > 1 {
+		if c := LookupCommand(os.Args[1]); c != nil {
+			RunCommand(c, os.Args[2:])
+			return
+		}
+	}
 
-	err = ParseFlags(os.Args, settings)
+	flags, err := ParseFlags(os.Args)
+	if err != nil {
+		panic(err)
+	}
+	settings, err := LoadValidSettings(flags)
 	if err != nil {
 		panic(err)
 	}
-	if settings.Daemon {
+	if settings.Detach && !Detached() {
+		pid, err := Detach(settings)
+		if err != nil {
+			panic(err)
+		}
+		log.Printf("daemon started (pid %d)", pid)
+		return
+	}
+	if path := settings.logFile(); path != "" {
+		l, err := OpenLogFile(path)
+		if err != nil {
+			panic(err)
+		}
+		l.ReopenOnSignal()
+	}
+	if settings.Daemon || settings.Detach {
 		d := NewSettingsDaemon(settings)
-		if err = Run(d); err != nil {
+		if err = d.AcquireLocks(); err != nil {
 			panic(err)
 		}
-	} else {
-		rw := NewSettingsReaderWriter(settings.SysfsPath)
-		if err = rw.Set(settings); err != nil {
+		if settings.User != "" {
+			if err = d.SeparatePrivileges(settings.User); err != nil {
+				panic(err)
+			}
+		}
+		if settings.Sandbox {
+			Sandbox(settings)
+		}
+		err = Run(d)
+		d.ReleaseLocks()
+		if err != nil {
 			panic(err)
 		}
+	} else if err = applyOnce(settings, SourceStartup, flags.Wait()); err != nil {
+		panic(err)
 	}
 }
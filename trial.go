@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// ErrNoTrial indicates that there is no change to confirm.
+var ErrNoTrial = errors.New("no change to confirm")
+
+// trial is a change that is reverted unless it is confirmed in time.
+type trial struct {
+	settings   *Settings         // settings are the settings before the change.
+	state      State             // state is the runtime state before the change.
+	snapshot   map[string]string // snapshot are the device values before the change.
+	timer      *time.Timer       // timer reverts the change.
+	generation int               // generation counts the extensions of the trial.
+}
+
+// try makes the change and reverts it after timeout unless it is confirmed.
+// Changes made during a trial become part of it and a revert restores the
+// values from before its first change. A timeout of 0 outside of a trial
+// makes the change right away. The caller applies the settings.
+func (d *SettingsDaemon) try(timeout time.Duration, change func()) error {
+	d.writing.Lock()
+	d.Lock()
+	started := timeout > 0 && d.trial == nil
+	if started {
+		snapshot, err := d.rw.Snapshot(d.Settings.Effective(d.state).DeviceKeys())
+		if err != nil {
+			d.Unlock()
+			d.writing.Unlock()
+			return err
+		}
+		d.trial = &trial{settings: d.Settings, state: d.state, snapshot: snapshot}
+	}
+	if t := d.trial; t != nil && timeout > 0 {
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+		t.generation++
+		generation := t.generation
+		t.timer = time.AfterFunc(timeout, func() { d.revert(t, generation) })
+	}
+	change()
+	if t := d.trial; t != nil {
+		// the extra attributes added by the change are not written yet
+		if snapshot, err := d.rw.Snapshot(d.Settings.Effective(d.state).Extra.Keys()); err == nil {
+			for key, value := range snapshot {
+				if _, ok := t.snapshot[key]; !ok {
+					t.snapshot[key] = value
+				}
+			}
+		}
+	}
+	d.Unlock()
+	d.writing.Unlock()
+	if started {
+		d.emit(Event{Type: EventTrialStarted, Time: time.Now(),
+			Message: fmt.Sprintf("reverting in %v unless confirmed", timeout)})
+	}
+	return nil
+}
+
+// confirm keeps the changes of the current trial.
+func (d *SettingsDaemon) confirm() error {
+	d.Lock()
+	t := d.trial
+	if t == nil {
+		d.Unlock()
+		return ErrNoTrial
+	}
+	t.timer.Stop()
+	d.trial = nil
+	settings := d.Settings
+	d.Unlock()
+	if settings != t.settings {
+		d.saveLastKnownGood(settings)
+	}
+	d.emit(Event{Type: EventTrialConfirmed, Time: time.Now(), Message: "keeping the changes"})
+	return nil
+}
+
+// revert restores the settings, the state and the device values from before
+// the trial, unless the trial was confirmed or extended in the meantime.
+func (d *SettingsDaemon) revert(t *trial, generation int) {
+	d.writing.Lock()
+	d.Lock()
+	if d.trial != t || t.generation != generation {
+		d.Unlock()
+		d.writing.Unlock()
+		return
+	}
+	d.trial = nil
+	d.setSettings(t.settings)
+	d.state.Profile, d.state.Values, d.state.Extra = t.state.Profile, t.state.Values, t.state.Extra
+	rw, keys := d.rw, make([]string, 0, len(t.snapshot))
+	d.Unlock()
+	for key := range t.snapshot {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	before, _ := rw.Snapshot(keys)
+	for _, key := range keys {
+		if err := rw.SetValue(key, t.snapshot[key]); err != nil {
+			log.Printf("could not restore %v: %v", key, err)
+		}
+	}
+	d.history.Record(SourceTrial, rw, keys, before)
+	d.writing.Unlock()
+	d.emit(Event{Type: EventTrialReverted, Time: time.Now(), Message: "not confirmed in time, restored the previous values"})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDaemon(t *testing.T, dir string) *SettingsDaemon {
+	s := newSettings()
+	s.SysfsPath = dir
+	s.StateDir = filepath.Join(dir, "state")
+	s.Values["sensitivity"] = 128
+	s.Profiles = Profiles{"precise": {"speed": 80}}
+	d := NewSettingsDaemon(s)
+	fakeWrites(d.rw)
+	if err := d.applySettings(SourceStartup); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func readAttribute(t *testing.T, dir, key string) string {
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(bytes))
+}
+
+func TestSettingsDaemon_TrialRevert(t *testing.T) {
+	dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128", "speed": "97"})
+	defer cleanup()
+	d := newTestDaemon(t, dir)
+	var events []EventType
+	reverted := make(chan bool, 1)
+	d.OnEvent = func(e Event) {
+		events = append(events, e.Type)
+		if e.Type == EventTrialReverted {
+			reverted <- true
+		}
+	}
+
+	if resp := d.handleControl(&ControlRequest{Command: "set", Args: []string{"sensitivity=1"}, Trial: 50 * time.Millisecond}); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	if resp := d.handleControl(&ControlRequest{Command: "profile", Args: []string{"precise"}}); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	if v := readAttribute(t, dir, "sensitivity"); v != "1" {
+		t.Fatalf("expected %v, got %v", "1", v)
+	}
+	if v := readAttribute(t, dir, "speed"); v != "80" {
+		t.Fatalf("expected %v, got %v", "80", v)
+	}
+
+	select {
+	case <-reverted:
+	case <-time.After(time.Second):
+		t.Fatal("expected the trial to be reverted")
+	}
+	if v := readAttribute(t, dir, "sensitivity"); v != "128" {
+		t.Fatalf("expected %v, got %v", "128", v)
+	}
+	if v := readAttribute(t, dir, "speed"); v != "97" {
+		t.Fatalf("expected %v, got %v", "97", v)
+	}
+	if d.state.Profile != "" || len(d.state.Values) != 0 {
+		t.Fatalf("expected the runtime state to be reverted, got %+v", d.state)
+	}
+	if len(events) != 2 || events[0] != EventTrialStarted || events[1] != EventTrialReverted {
+		t.Fatalf("expected %v, got %v", []EventType{EventTrialStarted, EventTrialReverted}, events)
+	}
+}
+
+func TestSettingsDaemon_TrialConfirm(t *testing.T) {
+	dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128"})
+	defer cleanup()
+	d := newTestDaemon(t, dir)
+
+	if resp := d.handleControl(&ControlRequest{Command: "confirm"}); resp.Error != ErrNoTrial.Error() {
+		t.Fatalf("expected %v, got %v", ErrNoTrial, resp.Error)
+	}
+	if resp := d.handleControl(&ControlRequest{Command: "set", Args: []string{"sensitivity=200"}, Trial: 50 * time.Millisecond}); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	if resp := d.handleControl(&ControlRequest{Command: "confirm"}); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if v := readAttribute(t, dir, "sensitivity"); v != "200" {
+		t.Fatalf("expected %v, got %v", "200", v)
+	}
+
+	for _, req := range []*ControlRequest{
+		{Command: "set", Args: []string{"sensitivity=256"}},
+		{Command: "set", Args: []string{"foo=1"}},
+		{Command: "profile", Args: []string{"fast"}},
+	} {
+		if resp := d.handleControl(req); resp.Error == "" {
+			t.Fatalf("expected error for %v", req.Args)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"log"
+	"os/exec"
+	"strings"
 	"time"
 )
 
@@ -58,3 +62,12 @@ func WrapError(fn func() error) <-chan error {
 	}()
 	return c
 }
+
+// execLogged runs the commands one after another and logs their failures.
+func execLogged(cmds ...[]string) {
+	for _, cmd := range cmds {
+		if out, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput(); err != nil {
+			log.Printf("%s: %v: %s", strings.Join(cmd, " "), err, bytes.TrimSpace(out))
+		}
+	}
+}
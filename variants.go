@@ -0,0 +1,71 @@
+package main
+
+import "strings"
+
+// Variant is the vendor variant of a TrackPoint.
+type Variant string
+
+// The TrackPoint variants the kernel reports in the device name.
+const (
+	VariantIBM     Variant = "IBM"
+	VariantALPS    Variant = "ALPS"
+	VariantElan    Variant = "Elan"
+	VariantNXP     Variant = "NXP"
+	VariantUnknown Variant = "unknown"
+)
+
+// VariantInfo describes what a TrackPoint variant supports.
+type VariantInfo struct {
+	Attributes []string // Attributes are the supported attributes, nil if all are supported.
+}
+
+// Variants are the known TrackPoint variants. Since Linux 4.17 the kernel
+// only exposes sensitivity and press_to_select for non-IBM variants. It
+// resets all variants to the same defaults, the Attribute.Default values.
+var Variants = map[Variant]*VariantInfo{
+	VariantIBM:  {},
+	VariantALPS: {Attributes: []string{"sensitivity", "press_to_select"}},
+	VariantElan: {Attributes: []string{"sensitivity", "press_to_select"}},
+	VariantNXP:  {Attributes: []string{"sensitivity", "press_to_select"}},
+}
+
+// ParseVariant parses the variant from a device name like "TPPS/2 Elan TrackPoint".
+func ParseVariant(name string) Variant {
+	if !strings.Contains(name, TrackPointName) {
+		return VariantUnknown
+	}
+	for _, field := range strings.Fields(name) {
+		for v := range Variants {
+			if strings.EqualFold(field, string(v)) {
+				return v
+			}
+		}
+	}
+	return VariantUnknown
+}
+
+// Info returns the description of the variant. Unknown variants are
+// treated like IBM TrackPoints.
+func (v Variant) Info() *VariantInfo {
+	if info, ok := Variants[v]; ok {
+		return info
+	}
+	return Variants[VariantIBM]
+}
+
+// Supports checks if the variant supports the attribute.
+func (v Variant) Supports(name string) bool {
+	info := v.Info()
+	return info.Attributes == nil || contains(info.Attributes, name)
+}
+
+// Defaults returns the defaults of the supported attributes.
+func (v Variant) Defaults() Values {
+	values := make(Values)
+	for _, a := range Attributes {
+		if v.Supports(a.Name) {
+			values[a.Name] = a.Default
+		}
+	}
+	return values
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestParseVariant(t *testing.T) {
+	tests := map[string]Variant{
+		"TPPS/2 IBM TrackPoint":      VariantIBM,
+		"TPPS/2 Elan TrackPoint":     VariantElan,
+		"TPPS/2 ALPS TrackPoint":     VariantALPS,
+		"TPPS/2 NXP TrackPoint":      VariantNXP,
+		"TPPS/2 TrackPoint":          VariantUnknown,
+		"SynPS/2 Synaptics TouchPad": VariantUnknown,
+	}
+	for name, expected := range tests {
+		if actual := ParseVariant(name); actual != expected {
+			t.Fatalf("expected %v for %q, got %v", expected, name, actual)
+		}
+	}
+}
+
+func TestVariant_Defaults(t *testing.T) {
+	if d := VariantIBM.Defaults(); len(d) != len(Attributes) {
+		t.Fatalf("expected %v defaults, got %v", len(Attributes), d)
+	}
+	d := VariantElan.Defaults()
+	if len(d) != 2 || d.Get("sensitivity") != "128" || d.Get("press_to_select") != "0" {
+		t.Fatalf("unexpected defaults %v", d)
+	}
+	if VariantALPS.Supports("drift_time") {
+		t.Fatal("expected drift_time to be unsupported")
+	}
+	if !VariantUnknown.Supports("drift_time") {
+		t.Fatal("expected drift_time to be supported")
+	}
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -21,29 +24,175 @@ type SettingsReaderWriter struct {
 	MaxWriteAttempts    uint          // MaxWriteAttempts is the maximum number of attempts to write a file.
 	WriteTimeout        time.Duration // WriteTimeout is the time a single write may take.
 	TimeBetweenAttempts time.Duration // TimeBetweenAttempts is the time between write attempts
+	warnedMu            sync.Mutex
+	warned              map[string]bool
+	write               func(path, value string) error // write writes a value, replaced in tests.
 }
 
 // NewSettingsReaderWriter creates a new SettingsReaderWriter.
 func NewSettingsReaderWriter(path string) *SettingsReaderWriter {
-	return &SettingsReaderWriter{
+	t := &SettingsReaderWriter{
 		SysfsPath:           path,
 		MaxWriteAttempts:    10,
 		WriteTimeout:        3 * time.Second,
 		TimeBetweenAttempts: 10 * time.Second,
+		warned:              make(map[string]bool),
 	}
+	t.write = t.writeValue
+	return t
 }
 
-// Set writes the settings.
+// Attributes lists the attributes the device has.
+func (t *SettingsReaderWriter) Attributes() ([]string, error) {
+	files, err := ioutil.ReadDir(t.SysfsPath)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if f.Mode().IsRegular() {
+			names = append(names, f.Name())
+		}
+	}
+	return names, nil
+}
+
+// Snapshot reads the values of the given keys the device has.
+func (t *SettingsReaderWriter) Snapshot(keys []string) (map[string]string, error) {
+	available, err := t.Attributes()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := make(map[string]string)
+	for _, key := range keys {
+		if !contains(available, key) {
+			continue
+		}
+		if snapshot[key], err = t.GetValue(key); err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+// ApplyError reports the keys that could not be written and, in the
+// all-or-nothing mode, the keys that were restored to their previous values.
+type ApplyError struct {
+	Failed         map[string]error // Failed are the keys that could not be written.
+	RolledBack     []string         // RolledBack are the keys that were restored.
+	RollbackFailed []string         // RollbackFailed are the keys that could not be restored.
+}
+
+func (e *ApplyError) Error() string {
+	var failed []string
+	for key := range e.Failed {
+		failed = append(failed, key)
+	}
+	sort.Strings(failed)
+	for i, key := range failed {
+		failed[i] = fmt.Sprintf("%s (%v)", key, e.Failed[key])
+	}
+	msg := "could not write " + strings.Join(failed, ", ")
+	if len(e.RolledBack) > 0 {
+		msg += "; rolled back " + strings.Join(e.RolledBack, ", ")
+	}
+	if len(e.RollbackFailed) > 0 {
+		msg += "; could not roll back " + strings.Join(e.RollbackFailed, ", ")
+	}
+	return msg
+}
+
+// Set writes the settings. Attributes the device does not have are skipped.
+// Each value is retried on its own. If it still fails, the other values are
+// kept in the best-effort mode, while the all-or-nothing mode restores the
+// values written so far. The failures are reported as *ApplyError.
 func (t *SettingsReaderWriter) Set(settings *Settings) error {
-	return RetryWait(t.TimeBetweenAttempts, func(attempt uint) (bool, error) {
-		log.Printf("writing (attempt %v)", attempt)
-		err := settings.ForEach(t.SetValue)
-		if err != nil {
+	var available []string
+	err := RetryWait(t.TimeBetweenAttempts, func(attempt uint) (bool, error) {
+		var err error
+		if available, err = t.Attributes(); err != nil {
 			log.Print(err)
 			return attempt < t.MaxWriteAttempts, err
 		}
 		return false, nil
 	})
+	if err != nil {
+		return err
+	}
+	var keys, values []string
+	settings.ForEach(func(key, value string) error {
+		if !contains(available, key) {
+			t.warnUnsupported(key)
+		} else {
+			keys, values = append(keys, key), append(values, value)
+		}
+		return nil
+	})
+
+	log.Printf("writing (%v)", settings.ApplyMode)
+	var snapshot map[string]string
+	if settings.ApplyMode == ApplyAllOrNothing {
+		if snapshot, err = t.Snapshot(keys); err != nil {
+			return err
+		}
+	}
+	e := &ApplyError{Failed: make(map[string]error)}
+	var changed []string
+	for i, key := range keys {
+		if err := t.setValue(key, values[i]); err != nil {
+			e.Failed[key] = err
+			if snapshot != nil {
+				// the failed write may have changed the value as well
+				changed = append(changed, key)
+				break
+			}
+		} else if snapshot != nil && snapshot[key] != values[i] {
+			changed = append(changed, key)
+		}
+	}
+	if len(e.Failed) == 0 {
+		return nil
+	}
+	for i := len(changed) - 1; i >= 0; i-- {
+		key := changed[i]
+		if err := t.SetValue(key, snapshot[key]); err != nil {
+			log.Printf("%15v: could not roll back: %v", key, err)
+			e.RollbackFailed = append(e.RollbackFailed, key)
+		} else if _, failed := e.Failed[key]; !failed {
+			e.RolledBack = append(e.RolledBack, key)
+		}
+	}
+	return e
+}
+
+// setValue sets the value for a key, retrying up to MaxWriteAttempts times.
+func (t *SettingsReaderWriter) setValue(key, value string) error {
+	return RetryWait(t.TimeBetweenAttempts, func(attempt uint) (bool, error) {
+		if attempt > 1 {
+			log.Printf("%15v: retrying (attempt %v)", key, attempt)
+		}
+		err := t.SetValue(key, value)
+		if err != nil {
+			log.Printf("%15v: %v", key, err)
+		}
+		return attempt < t.MaxWriteAttempts, err
+	})
+}
+
+func (t *SettingsReaderWriter) warnUnsupported(key string) {
+	t.warnedMu.Lock()
+	warned := t.warned[key]
+	t.warned[key] = true
+	t.warnedMu.Unlock()
+	if warned {
+		return
+	}
+	a := LookupAttribute(key)
+	if release, err := KernelRelease(); err == nil && a != nil && CompareVersions(release, a.Since) < 0 {
+		log.Printf("%15v: not supported by the device (requires kernel %v or later), skipping", key, a.Since)
+	} else {
+		log.Printf("%15v: not supported by the device, skipping", key)
+	}
 }
 
 // SetValue sets the value for a key.
@@ -61,7 +210,7 @@ func (t *SettingsReaderWriter) SetValue(key, value string) error {
 	log.Printf("%15v: setting to %3v", key, value)
 
 	path := filepath.Join(t.SysfsPath, key)
-	if err := t.writeValue(path, value); err != nil {
+	if err := t.write(path, value); err != nil {
 		return err
 	}
 
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeDevice creates a device directory with the given attribute files.
+func fakeDevice(t *testing.T, attributes map[string]string) (dir string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "trackpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range attributes {
+		if err := ioutil.WriteFile(filepath.Join(dir, key), []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// fakeWrites lets rw overwrite the regular files of a fake device, which
+// unlike sysfs attributes do not replace their content when appended to.
+func fakeWrites(rw *SettingsReaderWriter) {
+	rw.TimeBetweenAttempts = 0
+	rw.write = func(path, value string) error {
+		return ioutil.WriteFile(path, []byte(value+"\n"), 0644)
+	}
+}
+
+func TestSettingsReaderWriter_Attributes(t *testing.T) {
+	dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128", "speed": "97"})
+	defer cleanup()
+	if err := os.Mkdir(filepath.Join(dir, "input"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	attributes, err := NewSettingsReaderWriter(dir).Attributes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attributes) != 2 || attributes[0] != "sensitivity" || attributes[1] != "speed" {
+		t.Fatalf("expected %v, got %v", []string{"sensitivity", "speed"}, attributes)
+	}
+}
+
+func TestSettingsReaderWriter_SetUnsupported(t *testing.T) {
+	dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128", "press_to_select": "0", "new_thing": "3"})
+	defer cleanup()
+
+	s := NewSettings()
+	s.Extra = Extra{"new_thing": "3"}
+	rw := NewSettingsReaderWriter(dir)
+	rw.MaxWriteAttempts = 1
+	if err := rw.Set(s); err != nil {
+		t.Fatal(err)
+	}
+	if !rw.warned["drift_time"] {
+		t.Fatal("expected a warning for drift_time")
+	}
+	if rw.warned["sensitivity"] || rw.warned["new_thing"] {
+		t.Fatal("expected no warning for supported attributes")
+	}
+}
+
+func TestSettingsReaderWriter_SetRollback(t *testing.T) {
+	tests := []struct {
+		mode       string
+		expected   map[string]string
+		rolledBack []string
+	}{
+		{ApplyBestEffort, map[string]string{"sensitivity": "200", "speed": "97", "inertia": "9"}, nil},
+		{ApplyAllOrNothing, map[string]string{"sensitivity": "128", "speed": "97", "inertia": "6"}, []string{"inertia", "sensitivity"}},
+	}
+	for _, test := range tests {
+		dir, cleanup := fakeDevice(t, map[string]string{"sensitivity": "128", "speed": "97", "inertia": "6"})
+		defer cleanup()
+		rw := NewSettingsReaderWriter(dir)
+		fakeWrites(rw)
+		rw.MaxWriteAttempts = 2
+		write, attempts := rw.write, 0
+		rw.write = func(path, value string) error {
+			if filepath.Base(path) == "speed" {
+				attempts++
+				return errors.New("device busy")
+			}
+			return write(path, value)
+		}
+
+		s := newSettings()
+		s.ApplyMode = test.mode
+		s.Values = Values{"sensitivity": 200, "speed": 100, "inertia": 9}
+		err := rw.Set(s)
+		e, ok := err.(*ApplyError)
+		if !ok {
+			t.Fatalf("expected *ApplyError, got %v", err)
+		}
+		if len(e.Failed) != 1 || e.Failed["speed"] == nil {
+			t.Fatalf("expected speed to fail, got %v", e)
+		}
+		if attempts != 2 {
+			t.Fatalf("expected %v attempts, got %v", 2, attempts)
+		}
+		if !reflect.DeepEqual(e.RolledBack, test.rolledBack) {
+			t.Fatalf("expected %v to be rolled back, got %v", test.rolledBack, e.RolledBack)
+		}
+		for key, value := range test.expected {
+			if actual, _ := rw.GetValue(key); actual != value {
+				t.Fatalf("expected %v for %v in %v mode, got %v", value, key, test.mode, actual)
+			}
+		}
+	}
+}